@@ -0,0 +1,158 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/szuecs/binary-patch/semver"
+)
+
+// Platform describes the artifact of a release for one system.
+type Platform struct {
+	Arch          string    `json:"arch"`
+	OS            string    `json:"os"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256,omitempty"`
+	SignatureType string    `json:"signature-type,omitempty"`
+	Uploaded      time.Time `json:"uploaded"`
+	Channel       string    `json:"channel"`
+	Rollout       int       `json:"rollout"`
+	State         string    `json:"state,omitempty"`
+}
+
+// VersionInfo describes a release with the artifacts of all systems.
+type VersionInfo struct {
+	Version   string     `json:"version"`
+	Platforms []Platform `json:"platforms"`
+}
+
+// platform returns the description of the artifact of u.
+func (svc *Service) platform(u *Update) (Platform, error) {
+	info, err := svc.Storage.Stat(u)
+	if err != nil {
+		return Platform{}, err
+	}
+	release, err := readRelease(svc.Storage, u)
+	if err != nil {
+		return Platform{}, err
+	}
+	p := Platform{
+		Arch:     u.System.Arch,
+		OS:       u.System.OS,
+		Size:     info.Size,
+		Uploaded: info.ModTime.UTC(),
+		Channel:  release.Channel,
+		Rollout:  release.Rollout,
+		State:    release.State,
+	}
+	if digest, err := readSidecar(svc.Storage, u, sidecarSHA256); err == nil {
+		p.SHA256 = strings.TrimSpace(string(digest))
+	}
+	if _, err := readSidecar(svc.Storage, u, sidecarSignature); err == nil {
+		p.SignatureType = release.signatureType()
+	}
+	return p, nil
+}
+
+// versionInfos returns all releases of application name, the newest
+// first.
+func (svc *Service) versionInfos(name string) ([]*VersionInfo, error) {
+	byVersion := make(map[string]*VersionInfo)
+	for system := range supported {
+		versions, err := svc.Storage.Versions(name, system)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			p, err := svc.platform(&Update{Name: name, Version: v, System: system})
+			if err != nil {
+				return nil, err
+			}
+			vi, ok := byVersion[v]
+			if !ok {
+				vi = &VersionInfo{Version: v}
+				byVersion[v] = vi
+			}
+			vi.Platforms = append(vi.Platforms, p)
+		}
+	}
+
+	infos := make([]*VersionInfo, 0, len(byVersion))
+	for _, vi := range byVersion {
+		sort.Slice(vi.Platforms, func(i, j int) bool {
+			return vi.Platforms[i].Arch+vi.Platforms[i].OS < vi.Platforms[j].Arch+vi.Platforms[j].OS
+		})
+		infos = append(infos, vi)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		c, err := semver.Compare(infos[i].Version, infos[j].Version)
+		if err != nil {
+			return infos[i].Version > infos[j].Version
+		}
+		return c > 0
+	})
+	return infos, nil
+}
+
+// AppsHandler handles /apps endpoint and returns the names of all
+// applications.
+func (svc *Service) AppsHandler(ginCtx *gin.Context) {
+	apps, err := svc.Storage.Apps()
+	if err != nil {
+		glog.Errorf("Failed to list applications: %v", err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list applications"})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, gin.H{"apps": apps})
+}
+
+// VersionsHandler handles /apps/:name/versions endpoint and returns
+// all releases of the application, the newest first.
+func (svc *Service) VersionsHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+	infos, err := svc.versionInfos(name)
+	if err != nil {
+		glog.Errorf("Failed to list versions of %s: %v", name, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list versions of application '%s'", name)})
+		return
+	}
+	if len(infos) == 0 {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' not found", name)})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, gin.H{"name": name, "versions": infos})
+}
+
+// VersionHandler handles /apps/:name/versions/:version endpoint and
+// returns the release with the artifacts of all systems.
+func (svc *Service) VersionHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+	version := ginCtx.Param("version")
+	vi := &VersionInfo{Version: version, Platforms: []Platform{}}
+	_, err := forEachPlatform(svc.Storage, name, version, func(u *Update) error {
+		p, err := svc.platform(u)
+		if err != nil {
+			return err
+		}
+		vi.Platforms = append(vi.Platforms, p)
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Failed to read release of %s %s: %v", name, version, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read application '%s' version %s", name, version)})
+		return
+	}
+	if len(vi.Platforms) == 0 {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' version %s not found", name, version)})
+		return
+	}
+	sort.Slice(vi.Platforms, func(i, j int) bool {
+		return vi.Platforms[i].Arch+vi.Platforms[i].OS < vi.Platforms[j].Arch+vi.Platforms[j].OS
+	})
+	ginCtx.JSON(http.StatusOK, gin.H{"name": name, "version": vi.Version, "platforms": vi.Platforms})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAppsHandlers(t *testing.T) {
+	store := newMemStorage()
+	for _, upload := range []*UploadData{
+		{Data: []byte("v0.0.9"), Version: "v0.0.9", Architecture: "amd64", OS: "linux"},
+		{Data: []byte("v0.0.10"), Version: "v0.0.10", Architecture: "amd64", OS: "linux", Signature: []byte("sig"), SignatureType: "ed25519", Channel: "beta"},
+		{Data: []byte("v0.0.10 darwin"), Version: "v0.0.10", Architecture: "amd64", OS: "darwin"},
+	} {
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	ctx, w := newTestContext("/apps")
+	svc.AppsHandler(ctx)
+	if w.Code != 200 || w.Body.String() != `{"apps":["foo"]}` {
+		t.Fatalf("Wrong apps %d: %s", w.Code, w.Body.String())
+	}
+
+	ctx, w = newTestContext("/apps/foo/versions")
+	svc.VersionsHandler(ctx)
+	var versions struct {
+		Versions []VersionInfo `json:"versions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", w.Body.String(), err)
+	}
+	if len(versions.Versions) != 2 || versions.Versions[0].Version != "v0.0.10" || len(versions.Versions[0].Platforms) != 2 {
+		t.Fatalf("Wrong versions: %s", w.Body.String())
+	}
+
+	ctx, w = newTestContext("/apps/foo/versions/v0.0.10")
+	ctx.Params = append(ctx.Params, gin.Param{Key: "version", Value: "v0.0.10"})
+	svc.VersionHandler(ctx)
+	var version VersionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &version); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", w.Body.String(), err)
+	}
+	if len(version.Platforms) != 2 {
+		t.Fatalf("Wrong platforms: %s", w.Body.String())
+	}
+	p := version.Platforms[1]
+	if p.OS != "linux" || p.Size != int64(len("v0.0.10")) || p.SHA256 == "" || p.SignatureType != "ed25519" || p.Channel != "beta" {
+		t.Fatalf("Wrong platform: %+v", p)
+	}
+
+	ctx, w = newTestContext("/apps/foo/versions/v0.0.1")
+	ctx.Params = append(ctx.Params, gin.Param{Key: "version", Value: "v0.0.1"})
+	svc.VersionHandler(ctx)
+	if ctx.Writer.Status() != 404 || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Fatalf("Wrong response %d for missing version: %s", ctx.Writer.Status(), w.Body.String())
+	}
+}
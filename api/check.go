@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/szuecs/binary-patch/semver"
+)
+
+// CheckResult reports, if an update is available for a client,
+// without sending the update.
+type CheckResult struct {
+	Available      bool   `json:"update-available"`
+	Version        string `json:"version"`
+	CurrentVersion string `json:"current-version"`
+	// Size is the size of the full binary of Version
+	Size int64 `json:"size,omitempty"`
+	// PatchSize is the size of the binary patch from
+	// CurrentVersion, if it is cached
+	PatchSize       int64  `json:"patch-size,omitempty"`
+	ReleaseNotesURL string `json:"release-notes-url,omitempty"`
+	// Mandatory is set, if Version or a skipped release is
+	// mandatory
+	Mandatory bool `json:"mandatory,omitempty"`
+}
+
+// mandatory returns true, if one of the releases newer than from up to
+// to is mandatory.
+func (svc *Service) mandatory(from, to *Update) (bool, error) {
+	versions, err := svc.Storage.Versions(from.Name, from.System)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		c, err := semver.Compare(v, from.Version)
+		if err != nil || c <= 0 {
+			continue
+		}
+		if c, err = semver.Compare(v, to.Version); err != nil || c > 0 {
+			continue
+		}
+		u := from.Clone()
+		u.Version = v
+		release, err := readRelease(svc.Storage, u)
+		if err != nil {
+			return false, err
+		}
+		if release.Mandatory && release.State != stateYanked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckHandler handles /check/:name endpoint. It reports the version
+// the client would get from the update endpoints.
+func (svc *Service) CheckHandler(ginCtx *gin.Context) {
+	update := newUpdateFromCtx(ginCtx)
+	if update == nil {
+		return
+	}
+	svc.warnState(ginCtx, update)
+	svc.seen(update)
+	latest, err := update.GetLatestVersion(svc.Storage)
+	if err != nil {
+		glog.Errorf("Failed to get latest version of %s: %v", update, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check application '%s' for updates", update.Name)})
+		return
+	}
+	result := CheckResult{
+		Available:      latest != update.Version,
+		Version:        latest,
+		CurrentVersion: update.Version,
+	}
+	if !result.Available {
+		ginCtx.JSON(http.StatusOK, result)
+		return
+	}
+
+	newUpdate := update.Clone()
+	newUpdate.Version = latest
+	info, err := svc.Storage.Stat(newUpdate)
+	if err != nil {
+		glog.Errorf("Failed to stat %s: %v", newUpdate, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check application '%s' for updates", update.Name)})
+		return
+	}
+	result.Size = info.Size
+	if patch, err := readSidecar(svc.Storage, newUpdate, patchExt(update.Version)); err == nil {
+		result.PatchSize = int64(len(patch))
+	}
+	release, err := readRelease(svc.Storage, newUpdate)
+	if err != nil {
+		glog.Errorf("Failed to read release of %s: %v", newUpdate, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check application '%s' for updates", update.Name)})
+		return
+	}
+	result.ReleaseNotesURL = release.ReleaseNotesURL
+	if result.Mandatory, err = svc.mandatory(update, newUpdate); err != nil {
+		glog.Errorf("Failed to check mandatory releases of %s: %v", update, err)
+	}
+	ginCtx.JSON(http.StatusOK, result)
+}
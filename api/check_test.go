@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheckHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, upload := range []*UploadData{
+		{Data: []byte("v0.0.1"), Version: "v0.0.1", Architecture: "amd64", OS: "linux"},
+		{Data: []byte("v0.0.2"), Version: "v0.0.2", Architecture: "amd64", OS: "linux", Mandatory: true},
+		{Data: []byte("binary v0.0.3"), Version: "v0.0.3", Architecture: "amd64", OS: "linux", ReleaseNotes: "https://example.com/v0.0.3"},
+	} {
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.PutSidecar(newTestUpdate("v0.0.3"), patchExt("v0.0.1"), []byte("patch")); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	for _, tc := range []struct {
+		version string
+		want    CheckResult
+	}{
+		{"v0.0.1", CheckResult{Available: true, Version: "v0.0.3", CurrentVersion: "v0.0.1", Size: 13, PatchSize: 5, ReleaseNotesURL: "https://example.com/v0.0.3", Mandatory: true}},
+		{"v0.0.2", CheckResult{Available: true, Version: "v0.0.3", CurrentVersion: "v0.0.2", Size: 13, ReleaseNotesURL: "https://example.com/v0.0.3"}},
+		{"v0.0.3", CheckResult{Version: "v0.0.3", CurrentVersion: "v0.0.3"}},
+	} {
+		ctx, w := newTestContext("/check/foo?version=" + tc.version + "&arch=amd64&os=linux")
+		svc.CheckHandler(ctx)
+		var got CheckResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to unmarshal %d %s: %v", w.Code, w.Body.String(), err)
+		}
+		if got != tc.want {
+			t.Errorf("Wrong result for %s: %+v, want %+v", tc.version, got, tc.want)
+		}
+	}
+}
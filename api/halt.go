@@ -0,0 +1,164 @@
+package api
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultRolloutHaltWindow is the time window of reports, which
+	// are considered to halt a rollout, if not configured otherwise.
+	defaultRolloutHaltWindow = time.Hour
+	// defaultRolloutHaltMinReports is the minimum number of reporting
+	// installations to halt a rollout, if not configured otherwise.
+	defaultRolloutHaltMinReports = 10
+	// maxRolloutHalts is the number of halts shown on the monitoring
+	// endpoint, older halts are dropped.
+	maxRolloutHalts = 100
+)
+
+// RolloutHalt is a release paused automatically, because too many
+// clients reported failed updates.
+type RolloutHalt struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Failures int       `json:"failures"`
+	Installs int       `json:"installs"`
+	Window   string    `json:"window"`
+	Time     time.Time `json:"time"`
+}
+
+// rolloutHaltConfig returns the failure ratio threshold, the window
+// and the minimum number of reporting installations to halt a
+// rollout. A threshold of 0 disables rollout halts.
+func rolloutHaltConfig() (float64, time.Duration, int) {
+	if cfg == nil {
+		return 0, defaultRolloutHaltWindow, defaultRolloutHaltMinReports
+	}
+	window, min := cfg.RolloutHaltWindow, cfg.RolloutHaltMinReports
+	if window <= 0 {
+		window = defaultRolloutHaltWindow
+	}
+	if min <= 0 {
+		min = defaultRolloutHaltMinReports
+	}
+	return cfg.RolloutHaltThreshold, window, min
+}
+
+// recentReports records the outcome reported by installation id for
+// version at now and returns the number of failed and all
+// installations, which reported version within window. Only the
+// latest outcome of an installation is counted.
+func (rs *reportStore) recentReports(name, version, id string, failed bool, now time.Time, window time.Duration) (int, int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.recent == nil {
+		rs.recent = make(map[string]map[string]map[string]reportEvent)
+	}
+	if rs.recent[name] == nil {
+		rs.recent[name] = make(map[string]map[string]reportEvent)
+	}
+	events := rs.recent[name][version]
+	if events == nil {
+		events = make(map[string]reportEvent)
+		rs.recent[name][version] = events
+	}
+	for i, e := range events {
+		if now.Sub(e.time) > window {
+			delete(events, i)
+		}
+	}
+	if _, ok := events[id]; ok || len(events) < maxReportedInstalls {
+		events[id] = reportEvent{time: now, failed: failed}
+	}
+
+	failures := 0
+	for _, e := range events {
+		if e.failed {
+			failures++
+		}
+	}
+	return failures, len(events)
+}
+
+// halted records the halt h and forgets the reports of the halted
+// version, such that a release resumed by hand is only halted again by
+// new reports. Only the latest halt of a version and the last
+// maxRolloutHalts halts are kept.
+func (rs *reportStore) halted(h RolloutHalt) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.recent[h.Name], h.Version)
+	halts := rs.halts[:0]
+	for _, old := range rs.halts {
+		if old.Name != h.Name || old.Version != h.Version {
+			halts = append(halts, old)
+		}
+	}
+	if len(halts) >= maxRolloutHalts {
+		halts = append(halts[:0], halts[len(halts)-maxRolloutHalts+1:]...)
+	}
+	rs.halts = append(halts, h)
+}
+
+// GetStats implements aspects.Aspect and shows the halted rollouts on
+// the monitoring endpoint.
+func (rs *reportStore) GetStats() interface{} {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	halts := make([]RolloutHalt, len(rs.halts))
+	copy(halts, rs.halts)
+	return halts
+}
+
+// Name implements aspects.Aspect.
+func (rs *reportStore) Name() string {
+	return "RolloutHalts"
+}
+
+// InRoot implements aspects.Aspect.
+func (rs *reportStore) InRoot() bool {
+	return true
+}
+
+// checkRollout pauses version of application name, if the ratio of
+// installations, which reported a failed update within the configured
+// window, exceeds the threshold. Reports without install ID are
+// ignored, since repeated reports could not be told apart. Paused
+// releases are not offered to clients anymore until they are set
+// active again by /yank/:name.
+func (svc *Service) checkRollout(name, version, id string, failed bool, now time.Time) {
+	threshold, window, min := rolloutHaltConfig()
+	if threshold <= 0 || id == "" {
+		return
+	}
+	failures, installs := svc.reports.recentReports(name, version, id, failed, now, window)
+	if installs < min || float64(failures) <= threshold*float64(installs) {
+		return
+	}
+
+	paused := false
+	_, err := updateReleases(svc.Storage, name, version, func(r *Release) {
+		if r.State == "" || r.State == stateDeprecated {
+			r.State = statePaused
+			paused = true
+		}
+	})
+	if err != nil {
+		glog.Errorf("Failed to halt rollout of %s %s: %v", name, version, err)
+		return
+	}
+	if !paused {
+		return
+	}
+	svc.reports.halted(RolloutHalt{
+		Name:     name,
+		Version:  version,
+		Failures: failures,
+		Installs: installs,
+		Window:   window.String(),
+		Time:     now.UTC(),
+	})
+	glog.Errorf("Halted rollout of %s %s: %d of %d clients reported failed updates within %s", name, version, failures, installs, window)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/szuecs/binary-patch/conf"
+)
+
+func TestService_checkRollout(t *testing.T) {
+	defer func(c *conf.Config) { cfg = c }(cfg)
+	cfg = &conf.Config{RolloutHaltThreshold: 0.2, RolloutHaltWindow: time.Hour, RolloutHaltMinReports: 5}
+
+	store := newMemStorage()
+	for _, v := range []string{"v1.0.0", "v1.1.0"} {
+		upload := &UploadData{Data: []byte(v), Version: v, Architecture: "amd64", OS: "linux"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+	u := newTestUpdate("v1.0.0")
+
+	now := time.Now()
+	installs := 0
+	report := func(outcome string, at time.Time) {
+		installs++
+		svc.checkRollout("foo", "v1.1.0", fmt.Sprintf("id-%d", installs), outcome != outcomeSuccess, at)
+	}
+	// old failures are outside of the window
+	report(outcomeRollback, now.Add(-2*time.Hour))
+	report(outcomeRollback, now.Add(-2*time.Hour))
+	for i := 0; i < 4; i++ {
+		report(outcomeSuccess, now)
+	}
+	report(outcomeChecksumFailure, now)
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.1.0" {
+		t.Fatalf("Rollout halted with 1 of 5 failures: %s %v", latest, err)
+	}
+
+	report(outcomeFailure, now)
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.0.0" {
+		t.Fatalf("Rollout not halted with 2 of 6 failures: %s %v", latest, err)
+	}
+	if release, err := readRelease(store, newTestUpdate("v1.1.0")); err != nil || release.State != statePaused {
+		t.Fatalf("Wrong release %+v: %v", release, err)
+	}
+	halts := svc.reports.GetStats().([]RolloutHalt)
+	if len(halts) != 1 || halts[0].Version != "v1.1.0" || halts[0].Failures != 2 || halts[0].Installs != 6 {
+		t.Fatalf("Wrong halts %+v", halts)
+	}
+
+	// a release resumed by hand is only halted by new reports
+	ctx, w := newTestRequestContext("PUT", "/yank/foo", `{"version": "v1.1.0", "state": "active"}`)
+	svc.YankHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+	report(outcomeFailure, now)
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.1.0" {
+		t.Fatalf("Resumed rollout halted by old reports: %s %v", latest, err)
+	}
+}
+
+func TestService_checkRolloutDisabled(t *testing.T) {
+	defer func(c *conf.Config) { cfg = c }(cfg)
+	cfg = &conf.Config{}
+
+	svc := &Service{Healthy: true, Storage: newMemStorage()}
+	for i := 0; i < 20; i++ {
+		svc.checkRollout("foo", fmt.Sprintf("v1.%d.0", i%2), fmt.Sprintf("id-%d", i), true, time.Now())
+	}
+	if len(svc.reports.recent) != 0 || len(svc.reports.halts) != 0 {
+		t.Fatalf("Disabled rollout halt recorded reports")
+	}
+}
+
+func TestService_checkRolloutDistinctInstalls(t *testing.T) {
+	defer func(c *conf.Config) { cfg = c }(cfg)
+	cfg = &conf.Config{RolloutHaltThreshold: 0.2, RolloutHaltWindow: time.Hour, RolloutHaltMinReports: 5}
+
+	store := newMemStorage()
+	for _, v := range []string{"v1.0.0", "v1.1.0"} {
+		upload := &UploadData{Data: []byte(v), Version: v, Architecture: "amd64", OS: "linux"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+	u := newTestUpdate("v1.0.0")
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		svc.checkRollout("foo", "v1.1.0", fmt.Sprintf("id-%d", i), false, now)
+	}
+	// repeated reports of one installation and reports without
+	// install ID do not halt the rollout
+	for i := 0; i < 10; i++ {
+		svc.checkRollout("foo", "v1.1.0", "broken", true, now)
+		svc.checkRollout("foo", "v1.1.0", "", true, now)
+	}
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.1.0" {
+		t.Fatalf("Rollout halted by 1 of 5 installations: %s %v", latest, err)
+	}
+
+	// the latest outcome of an installation counts
+	svc.checkRollout("foo", "v1.1.0", "id-0", true, now)
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.0.0" {
+		t.Fatalf("Rollout not halted by 2 of 5 installations: %s %v", latest, err)
+	}
+	halts := svc.reports.GetStats().([]RolloutHalt)
+	if len(halts) != 1 || halts[0].Failures != 2 || halts[0].Installs != 5 {
+		t.Fatalf("Wrong halts %+v", halts)
+	}
+}
+
+func TestReportStore_halted(t *testing.T) {
+	var rs reportStore
+	for i := 0; i < 2*maxRolloutHalts; i++ {
+		rs.halted(RolloutHalt{Name: "foo", Version: fmt.Sprintf("v1.%d.0", i)})
+	}
+	rs.halted(RolloutHalt{Name: "foo", Version: "v1.199.0", Failures: 1})
+	halts := rs.GetStats().([]RolloutHalt)
+	if len(halts) != maxRolloutHalts {
+		t.Fatalf("Wrong number of halts %d", len(halts))
+	}
+	if first, last := halts[0], halts[len(halts)-1]; first.Version != "v1.100.0" || last.Version != "v1.199.0" || last.Failures != 1 {
+		t.Fatalf("Wrong halts %+v ... %+v", first, last)
+	}
+	for _, h := range halts[:len(halts)-1] {
+		if h.Version == "v1.199.0" {
+			t.Fatalf("Halt of %s kept twice", h.Version)
+		}
+	}
+}
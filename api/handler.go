@@ -1,25 +1,26 @@
 package api
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
-	"github.com/kr/binarydist"
 	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/semver"
+	"github.com/szuecs/binary-patch/signature"
 )
 
 var (
-	basedir                = "/tmp/bindata" // TODO: choose by cli flag
 	errVersionMissing      = errors.New("Missing 'version' in query string")
 	errArchitectureMissing = errors.New("Missing 'arch' in query string")
 	errOSMissing           = errors.New("Missing 'os' in query string")
@@ -35,6 +36,12 @@ type Update struct {
 	Name    string
 	Version string
 	System  ArchAndOS
+	// Channel is the release channel the client wants to receive
+	// updates from. Releases of more stable channels are included.
+	Channel string
+	// InstallID identifies the client installation to decide if it
+	// is part of a staged rollout.
+	InstallID string
 }
 
 type ArchAndOS struct {
@@ -55,13 +62,23 @@ func newUpdate(ginCtx *gin.Context) (*Update, error) {
 	if !ok {
 		return nil, errOSMissing
 	}
+	if _, err := semver.Parse(version); err != nil {
+		return nil, err
+	}
+	name := ginCtx.Param("name")
+	channel := ginCtx.DefaultQuery("channel", channelsOf(name)[0])
+	if _, err := channelRank(name, channel); err != nil {
+		return nil, err
+	}
 	return &Update{
-		Name:    ginCtx.Param("name"),
+		Name:    name,
 		Version: version,
 		System: ArchAndOS{
 			Arch: goarch,
 			OS:   goos,
 		},
+		Channel:   channel,
+		InstallID: ginCtx.Query("install_id"),
 	}, nil
 }
 
@@ -73,6 +90,8 @@ func (u *Update) Clone() *Update {
 			Arch: u.System.Arch,
 			OS:   u.System.OS,
 		},
+		Channel:   u.Channel,
+		InstallID: u.InstallID,
 	}
 }
 
@@ -81,53 +100,123 @@ func (u *Update) isSupported() bool {
 	return ok
 }
 
-func (u *Update) GetFilepath() string {
-	return basedir + "/" + u.Name + "_" + u.Version + "_" + u.System.Arch + u.System.OS
+func (u *Update) String() string {
+	return u.Name + "_" + u.Version + "_" + u.System.Arch + u.System.OS
 }
 
-func (u *Update) GetReader(filepath string) (io.ReadCloser, error) {
-	fd, err := os.Open(filepath)
+// GetLatestVersion returns the version with the highest semantic
+// version precedence found in store for the application and system of
+// u or u.Version if there is no newer one. Only releases of u.Channel
+// or more stable channels are considered, an empty u.Channel is the
+// most stable channel. Releases in a staged rollout are only
+// considered if u.InstallID is part of the rollout. Yanked and paused
+// releases and releases replaced by a rollback are skipped, clients
+// running a rolled back release get the rollback target, if there is
+// no newer release. Artifacts with a version, which can not be parsed,
+// are skipped.
+func (u *Update) GetLatestVersion(store Storage) (string, error) {
+	versions, err := store.Versions(u.Name, u.System)
 	if err != nil {
-		return nil, errors.Wrap(errBinaryNotFound, filepath)
+		glog.Errorf("Could not list versions of %s, caused by: %v", u, err)
+		return "", err
+	}
+	rank := 0
+	if u.Channel != "" {
+		if rank, err = channelRank(u.Name, u.Channel); err != nil {
+			return "", err
+		}
+	}
+	current, err := semver.Parse(u.Version)
+	if err != nil {
+		return "", err
 	}
-	return fd, nil
-}
 
-func (u *Update) String() string {
-	return u.Name + "_" + u.Version + "_" + u.System.Arch + u.System.OS
-}
+	type candidate struct {
+		update  *Update
+		version *semver.Version
+		release *Release
+	}
+	var candidates []candidate
+	for _, v := range versions {
+		a := u.Clone()
+		a.Version = v
+		sv, err := semver.Parse(v)
+		if err != nil {
+			glog.Warningf("Skip artifact %s with invalid version: %v", a, err)
+			continue
+		}
+		release, err := readRelease(store, a)
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, candidate{update: a, version: sv, release: release})
+	}
+	rolledBack := func(v *semver.Version) bool {
+		for _, c := range candidates {
+			if c.release.Rollback != nil && c.release.Rollback.covers(c.version, v) {
+				return true
+			}
+		}
+		return false
+	}
 
-func (u *Update) versionFromFilename(filepath string) string {
-	return filepath[len(basedir)+1+len(u.Name)+1 : strings.LastIndex(filepath, "_")]
-}
+	latest, latestVersion := u.Version, current
+	for _, c := range candidates {
+		if c.version.Compare(latestVersion) <= 0 {
+			continue
+		}
+		if c.release.State == stateYanked || c.release.State == statePaused {
+			glog.V(2).Infof("Skip %s artifact %s", c.release.State, c.update)
+			continue
+		}
+		if rolledBack(c.version) {
+			glog.V(2).Infof("Skip rolled back artifact %s", c.update)
+			continue
+		}
+		r, err := channelRank(u.Name, c.release.Channel)
+		if err != nil {
+			glog.Warningf("Skip artifact %s: %v", c.update, err)
+			continue
+		}
+		if r > rank {
+			continue
+		}
+		if !inRollout(c.update, u.InstallID, c.release.Rollout) {
+			glog.V(2).Infof("Client %s is not part of the %d%% rollout of %s", u.InstallID, c.release.Rollout, c.update)
+			continue
+		}
+		latest = c.update.Version
+		latestVersion = c.version
+	}
+	if latest != u.Version {
+		return latest, nil
+	}
 
-func (u *Update) GetLatestVersion() string {
-	globStr := strings.Replace(u.String(), u.Version, "*", 1)
-	glog.V(2).Infof("Use globStr: %s", globStr)
-	files, err := filepath.Glob(fmt.Sprintf("%s/%s", basedir, globStr))
-	if err != nil {
-		glog.Errorf("Could not glob filepath '%s', caused by: %v", globStr, err)
-		return ""
-	}
-	glog.V(2).Infof("found %d files by glob", len(files))
-	latest := u.Version
-	for _, fname := range files {
-		v := u.versionFromFilename(fname)
-		if v > latest {
-			latest = v
+	var target *semver.Version
+	for _, c := range candidates {
+		if c.release.Rollback == nil || c.release.State == stateYanked || !c.release.Rollback.covers(c.version, current) {
+			continue
+		}
+		if target == nil || c.version.Compare(target) > 0 {
+			latest = c.update.Version
+			target = c.version
 		}
 	}
-	return latest
+	return latest, nil
 }
 
+// newUpdateFromCtx returns nil and aborts the request, if the query
+// is not valid.
 func newUpdateFromCtx(ginCtx *gin.Context) *Update {
 	update, err := newUpdate(ginCtx)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusBadRequest, err)
+		return nil
 	}
 
 	if !update.isSupported() {
 		ginCtx.AbortWithError(http.StatusBadRequest, errUnsupportedArchOS)
+		return nil
 	}
 	return update
 }
@@ -135,77 +224,190 @@ func newUpdateFromCtx(ginCtx *gin.Context) *Update {
 // UpdateHandler handles /update/:name endpoint
 func (svc *Service) UpdateHandler(ginCtx *gin.Context) {
 	update := newUpdateFromCtx(ginCtx)
+	if update == nil {
+		return
+	}
+	svc.warnState(ginCtx, update)
+	svc.seen(update)
 	curVersion := update.Version
-	if latest := update.GetLatestVersion(); latest != "" {
-		update.Version = latest
+	latest, err := update.GetLatestVersion(svc.Storage)
+	if err != nil {
+		ginCtx.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
+	update.Version = latest
 	glog.V(2).Infof("client hast version %s, we have latest version %s", curVersion, update.Version)
 	if update.Version == curVersion {
 		ginCtx.String(http.StatusNotModified, "")
 		return
 	}
-	fpath := update.GetFilepath()
-	rc, err := update.GetReader(fpath)
+	info, err := svc.Storage.Stat(update)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
+	}
+	content, err := svc.openContent(update, info.Size)
+	if err != nil {
+		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
+	}
+	defer content.Close()
+
+	// The ETag is the digest of the artifact, such that clients can
+	// resume a download with If-Range and get the full binary, if a
+	// newer version was released in between.
+	if digest, err := readSidecar(svc.Storage, update, sidecarSHA256); err == nil {
+		ginCtx.Header("ETag", fmt.Sprintf("%q", strings.TrimSpace(string(digest))))
+	}
+	ginCtx.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(ginCtx.Writer, ginCtx.Request, update.String(), info.ModTime, content)
+	glog.Infof("Served %d bytes to client to update %s", ginCtx.Writer.Size(), update)
+}
+
+// openContent returns the artifact of u with the given size, which
+// can seek to serve range requests. Storages, which can not seek, are
+// read with range requests, p.e. S3, or buffered.
+func (svc *Service) openContent(u *Update, size int64) (readSeekCloser, error) {
+	if ro, ok := backend(svc.Storage).(rangeOpener); ok {
+		return &rangeReader{store: ro, u: u, size: size}, nil
+	}
+	rc, err := svc.Storage.Open(u)
+	if err != nil {
+		return nil, err
+	}
+	if content, ok := rc.(readSeekCloser); ok {
+		return content, nil
 	}
 	defer rc.Close()
-	n, err := io.Copy(ginCtx.Writer, rc)
+	b, err := ioutil.ReadAll(rc)
 	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("Could not copy %s to client, caused by: %v", update.Name, err))
+		return nil, fmt.Errorf("Could not read %s, caused by: %v", u, err)
 	}
-	glog.Infof("Copied %d bytes to client to update %s", n, update)
+	return nopSeekCloser{bytes.NewReader(b)}, nil
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// nopSeekCloser is a readSeekCloser with a no-op Close.
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
 }
 
 // PatchUpdateHandler handles /patch-update/:name endpoint
-// TODO: use file cache to read and store binarydiff
 func (svc *Service) PatchUpdateHandler(ginCtx *gin.Context) {
 	newUpdate := newUpdateFromCtx(ginCtx)
-	latestVersion := newUpdate.GetLatestVersion()
+	if newUpdate == nil {
+		return
+	}
+	svc.warnState(ginCtx, newUpdate)
+	svc.seen(newUpdate)
+	latestVersion, err := newUpdate.GetLatestVersion(svc.Storage)
+	if err != nil {
+		ginCtx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	glog.V(2).Infof("client has version %s, we have latest version %s", newUpdate.Version, latestVersion)
 	if newUpdate.Version == latestVersion {
 		ginCtx.String(http.StatusNotModified, "")
 		return
 	}
 	oldUpdate := newUpdate.Clone()
-
 	newUpdate.Version = latestVersion
-	fpath := newUpdate.GetFilepath()
-	rcNew, err := newUpdate.GetReader(fpath)
+
+	binPatch, err := svc.getPatch(oldUpdate, newUpdate)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
-	defer rcNew.Close()
 
-	glog.Infof("old: %v, new: %v", oldUpdate, newUpdate)
-	fpath = oldUpdate.GetFilepath()
-	rcOld, err := oldUpdate.GetReader(fpath)
+	ginCtx.Header("Content-Type", "application/octet-stream")
+	ginCtx.Header("Content-Length", strconv.Itoa(len(binPatch)))
+	n, err := ginCtx.Writer.Write(binPatch)
 	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		ginCtx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to copy %s to client: %v", newUpdate.Name, err))
+		return
 	}
-	defer rcOld.Close()
-
-	buf := bytes.NewBuffer(nil)
-	rw := bufio.NewReadWriter(bufio.NewReader(buf), bufio.NewWriter(buf))
+	glog.Infof("Copied %d bytes to client to patch %s", n, newUpdate)
+}
 
-	err = binarydist.Diff(rcOld, rcNew, rw)
+// writeJSON writes data with Content-Length, such that clients can
+// report the progress of signed updates, which contain the binary.
+func writeJSON(ginCtx *gin.Context, data interface{}) {
+	b, err := json.Marshal(data)
 	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create a binary patch for %s: %v", newUpdate.Name, err))
+		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
-	rw.Flush()
+	ginCtx.Header("Content-Length", strconv.Itoa(len(b)))
+	ginCtx.Data(http.StatusOK, "application/json; charset=utf-8", b)
+}
 
-	n, err := io.Copy(ginCtx.Writer, rw)
+// signedUpdateData returns the signature, digest and signing key of
+// the artifact of u, which are sent to clients with signed updates.
+func (svc *Service) signedUpdateData(u *Update) (gin.H, error) {
+	sig, err := readSidecar(svc.Storage, u, sidecarSignature)
 	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to copy %s to client: %v", newUpdate.Name, err))
+		return nil, err
+	}
+	digest, err := readSidecar(svc.Storage, u, sidecarSHA256)
+	if err != nil {
+		return nil, err
+	}
+	release, err := readRelease(svc.Storage, u)
+	if err != nil {
+		return nil, err
 	}
-	glog.Infof("Copied %d bytes to client to patch %s", n, newUpdate)
 
+	data := gin.H{
+		"signature":      sig,
+		"signature-type": release.signatureType(),
+		"sha256":         digest,
+		"version":        u.Version,
+	}
+	if release.KeyID != "" {
+		data["key-id"] = release.KeyID
+		if chain := svc.keyrings[u.Name].chain(release.KeyID); len(chain) > 0 {
+			data["key-chain"] = chain
+		}
+	}
+	if rb := release.Rollback; rb != nil {
+		rollback := gin.H{
+			"from":           rb.From,
+			"to":             u.Version,
+			"signature":      rb.Signature,
+			"signature-type": rb.SignatureType,
+		}
+		if rb.KeyID != "" {
+			rollback["key-id"] = rb.KeyID
+			if chain := svc.keyrings[u.Name].chain(rb.KeyID); len(chain) > 0 {
+				rollback["key-chain"] = chain
+			}
+		}
+		data["rollback"] = rollback
+	}
+	return data, nil
 }
 
 // SignedUpdateHandler handles /signed-update/:name endpoint
 func (svc *Service) SignedUpdateHandler(ginCtx *gin.Context) {
 	newUpdate := newUpdateFromCtx(ginCtx)
-	latestVersion := newUpdate.GetLatestVersion()
+	if newUpdate == nil {
+		return
+	}
+	svc.warnState(ginCtx, newUpdate)
+	svc.seen(newUpdate)
+	latestVersion, err := newUpdate.GetLatestVersion(svc.Storage)
+	if err != nil {
+		ginCtx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	glog.V(2).Infof("client has version %s, we have latest version %s", newUpdate.Version, latestVersion)
 	if newUpdate.Version == latestVersion {
 		ginCtx.String(http.StatusNotModified, "")
@@ -213,85 +415,75 @@ func (svc *Service) SignedUpdateHandler(ginCtx *gin.Context) {
 	}
 
 	newUpdate.Version = latestVersion
-	fpath := newUpdate.GetFilepath()
-
-	binPatch, err := ioutil.ReadFile(fpath)
+	rc, err := svc.Storage.Open(newUpdate)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
-	signature, err := ioutil.ReadFile(fpath + ".signature")
+	defer rc.Close()
+
+	binPatch, err := ioutil.ReadAll(rc)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
-	digest, err := ioutil.ReadFile(fpath + ".sha256")
+	data, err := svc.signedUpdateData(newUpdate)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
+	data["patch"] = binPatch
 
-	ginCtx.JSON(http.StatusOK, gin.H{
-		"patch":     binPatch,
-		"signature": signature,
-		"sha256":    digest,
-	})
+	writeJSON(ginCtx, data)
 	glog.Infof("Copied %d bytes to client to update %s", len(binPatch), newUpdate)
 }
 
 // SignedPatchUpdateHandler handles /signed-patch-update/:name endpoint
 func (svc *Service) SignedPatchUpdateHandler(ginCtx *gin.Context) {
 	newUpdate := newUpdateFromCtx(ginCtx)
-	latestVersion := newUpdate.GetLatestVersion()
+	if newUpdate == nil {
+		return
+	}
+	svc.warnState(ginCtx, newUpdate)
+	svc.seen(newUpdate)
+	latestVersion, err := newUpdate.GetLatestVersion(svc.Storage)
+	if err != nil {
+		ginCtx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	glog.V(2).Infof("client has version %s, we have latest version %s", newUpdate.Version, latestVersion)
 	if newUpdate.Version == latestVersion {
 		ginCtx.String(http.StatusNotModified, "")
 		return
 	}
 	oldUpdate := newUpdate.Clone()
-
 	newUpdate.Version = latestVersion
-	fpath := newUpdate.GetFilepath()
-	rcNew, err := newUpdate.GetReader(fpath)
-	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
-	}
-	defer rcNew.Close()
 
-	glog.Infof("old: %v, new: %v", oldUpdate, newUpdate)
-	oldFpath := oldUpdate.GetFilepath()
-	rcOld, err := oldUpdate.GetReader(oldFpath)
+	binPatch, err := svc.getPatch(oldUpdate, newUpdate)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
-	defer rcOld.Close()
-
-	buf := bytes.NewBuffer(nil)
-	rw := bufio.NewReadWriter(bufio.NewReader(buf), bufio.NewWriter(buf))
-
-	err = binarydist.Diff(rcOld, rcNew, rw)
-	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create a binary patch for %s: %v", newUpdate.Name, err))
-	}
-	rw.Flush()
 
-	binPatch, err := ioutil.ReadAll(rw)
+	data, err := svc.signedUpdateData(newUpdate)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
 
-	signature, err := ioutil.ReadFile(fpath + ".signature")
+	fromDigest, err := readSidecar(svc.Storage, oldUpdate, sidecarSHA256)
 	if err != nil {
 		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
+		return
 	}
 
-	digest, err := ioutil.ReadFile(fpath + ".sha256")
-	if err != nil {
-		ginCtx.AbortWithError(http.StatusInternalServerError, err) // TODO: AbortWithError creates StackTraces, we want to have 4xx and an error log
-	}
+	patchDigest := sha256.Sum256(binPatch)
+	data["patch"] = binPatch
+	data["patch-sha256"] = fmt.Sprintf("%x", patchDigest)
+	data["from-sha256"] = strings.TrimSpace(string(fromDigest))
+	data["to-sha256"] = strings.TrimSpace(string(data["sha256"].([]byte)))
 
-	ginCtx.JSON(http.StatusOK, gin.H{
-		"patch":     binPatch,
-		"signature": signature,
-		"sha256":    digest,
-	})
+	writeJSON(ginCtx, data)
 
 	glog.Infof("Copied %d bytes patch to client to patch %s", len(binPatch), newUpdate)
 }
@@ -300,16 +492,42 @@ func (svc *Service) SignedPatchUpdateHandler(ginCtx *gin.Context) {
 // update, signed update or signed patch update. You need to sign your
 // data if you want to provide signed updates.
 type UploadData struct {
-	Data          []byte `json:"data"`                     // the new binary version
-	Version       string `json:"version"`                  // version string
-	Architecture  string `json:"arch"`                     // architecture, p.e. amd64
-	OS            string `json:"os"`                       // operating system, p.e. linux
-	Signature     []byte `json:"signature,omitempty"`      // DER encoded signature
-	SignatureType string `json:"signature-type,omitempty"` // ecdsa
+	Data          []byte `json:"data"`                        // the new binary version
+	Version       string `json:"version"`                     // version string
+	Architecture  string `json:"arch"`                        // architecture, p.e. amd64
+	OS            string `json:"os"`                          // operating system, p.e. linux
+	Signature     []byte `json:"signature,omitempty"`         // signature of the SHA256 digest of the data
+	SignatureType string `json:"signature-type,omitempty"`    // ecdsa, ed25519 or rsa-pss
+	Channel       string `json:"channel,omitempty"`           // release channel, p.e. stable (default), beta or nightly
+	Rollout       *int   `json:"rollout,omitempty"`           // percentage of clients, which are offered the release, defaults to 100
+	KeyID         string `json:"-"`                           // ID of the signing key, only set by the server if it verifies the signature
+	ReleaseNotes  string `json:"release-notes-url,omitempty"` // URL of the release notes
+	Mandatory     bool   `json:"mandatory,omitempty"`         // clients should not skip the release
+	SHA256        string `json:"sha256,omitempty"`            // hex encoded SHA256 of the data expected by the uploader
+
+	body   *os.File // spooled binary of streamed uploads, used instead of Data
+	digest []byte   // SHA256 of body
+}
+
+// sha256 returns the SHA256 digest of the uploaded binary.
+func (ud *UploadData) sha256() []byte {
+	if ud.body != nil {
+		return ud.digest
+	}
+	hash := sha256.Sum256(ud.Data)
+	return hash[:]
+}
+
+// reader returns the uploaded binary.
+func (ud *UploadData) reader() io.Reader {
+	if ud.body != nil {
+		return ud.body
+	}
+	return bytes.NewReader(ud.Data)
 }
 
-func (ud *UploadData) Save(application string) error {
-	up := &Update{
+func (ud *UploadData) update(application string) *Update {
+	return &Update{
 		Name:    application,
 		Version: ud.Version,
 		System: ArchAndOS{
@@ -317,59 +535,59 @@ func (ud *UploadData) Save(application string) error {
 			OS:   ud.OS,
 		},
 	}
-	filepath := up.GetFilepath()
-	_, err := os.Stat(filepath)
-	if err == nil {
-		glog.Errorf("Filepath %s already exists: %v", filepath, err)
-		return fmt.Errorf("%s already exists", filepath)
-	}
-	if !os.IsNotExist(err) {
-		glog.Errorf("Failed while doing stat(%s): %v", filepath, err)
-		return fmt.Errorf("Failed to save %s", filepath)
-	}
+}
 
-	ud.Write(filepath, ud.Data)
-	// TODO make filepath executable
+// Save stores the uploaded data of application together with its
+// signature and sha256 sidecars in store.
+func (ud *UploadData) Save(store Storage, application string) error {
+	up := ud.update(application)
 
+	sidecars := make(map[string][]byte)
+	release := defaultRelease(application)
 	// TODO: signature length is fixed size
-	if len(ud.Signature) > 0 && validSignatureType(ud.SignatureType) {
-		// TODO check if this works as expected
-		err = ud.Write(filepath+".signature", ud.Signature)
-		if err != nil {
-			glog.Errorf("Failed to write %s.signature: %v", filepath, err)
-			return fmt.Errorf("Failed to save %s.signature", filepath)
-		}
+	if len(ud.Signature) > 0 && signature.Valid(ud.SignatureType) {
+		sidecars[sidecarSignature] = ud.Signature
+		release.SignatureType = ud.SignatureType
+		release.KeyID = ud.KeyID
 	}
 
-	// TODO check if this works as expected
-	hash := sha256.Sum256(ud.Data)
-	sum := fmt.Sprintf("%x", hash)
-	glog.Infof("Wrote sha256: %s", sum)
-	err = ud.Write(filepath+".sha256", []byte(sum))
-	if err != nil {
-		glog.Errorf("Failed to write %s.sha256: %v", filepath, err)
-		return fmt.Errorf("Failed to save %s.sha256", filepath)
+	sum := fmt.Sprintf("%x", ud.sha256())
+	sidecars[sidecarSHA256] = []byte(sum)
+
+	if ud.Channel != "" {
+		release.Channel = ud.Channel
 	}
-	return nil
-}
+	if ud.Rollout != nil {
+		release.Rollout = *ud.Rollout
+	}
+	release.ReleaseNotesURL = ud.ReleaseNotes
+	release.Mandatory = ud.Mandatory
+	sidecars[sidecarRelease] = release.marshal()
 
-func validSignatureType(s string) bool {
-	signatures := map[string]bool{
-		"ecdsa": true,
+	if err := store.Put(up, ud.reader(), sidecars); err != nil {
+		glog.Errorf("Failed to save %s: %v", up, err)
+		return fmt.Errorf("Failed to save %s: %v", up, err)
 	}
-	_, ok := signatures[s]
-	return ok
+	glog.Infof("Wrote sha256: %s", sum)
+	return nil
 }
 
-func (ud *UploadData) Write(filepath string, data []byte) error {
-	fd, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE, 0440)
+// verifyUpload verifies the signature of the uploaded data against
+// the keyring of application and sets the ID of the verifying
+// key. Applications without keyring accept all uploads.
+func (svc *Service) verifyUpload(application string, ud *UploadData) error {
+	kr, ok := svc.keyrings[application]
+	if !ok {
+		return nil
+	}
+	key, err := kr.verify(ud.SignatureType, ud.sha256(), ud.Signature)
 	if err != nil {
+		glog.Errorf("Failed to verify signature of %s: %v", ud.update(application), err)
 		return err
 	}
-	defer fd.Close()
-
-	_, err = fd.Write(data)
-	return err
+	glog.Infof("Signature of %s verified by key %s", ud.update(application), key.id)
+	ud.KeyID = key.id
+	return nil
 }
 
 func returnUploadErr(msg string) gin.H {
@@ -380,8 +598,10 @@ func returnUploadErr(msg string) gin.H {
 			"version":        "v0.0.1",
 			"arch":           "amd64",
 			"os":             "linux",
-			"signature":      "Base64-encoded-DER-signature-of-the-binary-data",
-			"signature-type": "ecdsa"},
+			"signature":      "Base64-encoded-signature-of-the-binary-data",
+			"signature-type": "ecdsa",
+			"channel":        "stable",
+			"sha256":         "hex-encoded-sha256-of-the-binary-data"},
 	}
 }
 
@@ -389,26 +609,104 @@ func returnUploadErr(msg string) gin.H {
 func (svc *Service) UploadHandler(ginCtx *gin.Context) {
 	name := ginCtx.Param("name")
 
-	if ginCtx.ContentType() != "application/json" {
-		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Content-Type: application/json required for application '%s'", name)))
+	var upload *UploadData
+	var err error
+	switch ginCtx.ContentType() {
+	case "application/json":
+		// base64 encoding inflates the binary by 4/3
+		ginCtx.Request.Body = http.MaxBytesReader(ginCtx.Writer, ginCtx.Request.Body, maxUploadSize()/3*4+4096)
+		upload = &UploadData{}
+		if err = ginCtx.ShouldBindJSON(upload); err != nil {
+			if isBodyTooLarge(err) {
+				err = errors.Wrapf(errUploadTooLarge, "%d bytes", maxUploadSize())
+				break
+			}
+			ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)))
+			return
+		}
+		if int64(len(upload.Data)) > maxUploadSize() {
+			err = errors.Wrapf(errUploadTooLarge, "%d bytes", maxUploadSize())
+		}
+	case "application/octet-stream":
+		upload, err = readRawUpload(ginCtx.Request)
+	case "multipart/form-data":
+		upload, err = readMultipartUpload(ginCtx.Request)
+	default:
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Content-Type: application/json, application/octet-stream or multipart/form-data required for application '%s'", name)))
+		return
+	}
+	if errors.Cause(err) == errUploadTooLarge {
+		ginCtx.JSON(http.StatusRequestEntityTooLarge, returnUploadErr(fmt.Sprintf("Failed to read upload of application '%s': %v", name, err)))
 		return
 	}
+	if err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Failed to read upload of application '%s': %v", name, err)))
+		return
+	}
+	defer upload.Close()
 
-	var upload UploadData
-	if err := ginCtx.BindJSON(&upload); err != nil {
-		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)))
+	if _, err := semver.Parse(upload.Version); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Invalid version of application '%s': %v", name, err)))
 		return
 	}
 
-	if err := upload.Save(name); err != nil {
+	if !upload.update(name).isSupported() {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Failed to save provided data for application '%s': %v", name, errUnsupportedArchOS)))
+		return
+	}
+
+	if upload.Channel != "" {
+		if _, err := channelRank(name, upload.Channel); err != nil {
+			ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Invalid channel of application '%s': %v", name, err)))
+			return
+		}
+	}
+
+	if upload.Rollout != nil && (*upload.Rollout < 0 || *upload.Rollout > 100) {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Invalid rollout %d%% of application '%s'", *upload.Rollout, name)))
+		return
+	}
+
+	if upload.ReleaseNotes != "" {
+		if _, err := url.ParseRequestURI(upload.ReleaseNotes); err != nil {
+			ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Invalid release notes URL of application '%s': %v", name, err)))
+			return
+		}
+	}
+
+	if len(upload.Signature) > 0 && !signature.Valid(upload.SignatureType) {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Unknown signature-type %q of application '%s'", upload.SignatureType, name)))
+		return
+	}
+
+	sum := fmt.Sprintf("%x", upload.sha256())
+	expected, err := expectedDigest(ginCtx.Request, upload)
+	if err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Invalid digest of application '%s': %v", name, err)))
+		return
+	}
+	if expected != "" && expected != sum {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("SHA256 of application '%s' version %s is %s, expected %s", name, upload.Version, sum, expected)))
+		return
+	}
+
+	if err := svc.verifyUpload(name, upload); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Signature of application '%s' version %s rejected: %v", name, upload.Version, err)))
+		return
+	}
+
+	if err := upload.Save(svc.Storage, name); err != nil {
 		ginCtx.JSON(http.StatusUnprocessableEntity, returnUploadErr(fmt.Sprintf("Failed to save provided data for application '%s': %v", name, err)))
 		return
 	}
+	if cfg != nil && cfg.PatchCacheVersions > 0 {
+		go svc.precomputePatches(upload.update(name), cfg.PatchCacheVersions)
+	}
 
 	if len(upload.Signature) > 0 {
-		ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("uploaded signed application '%s' version %s for OS %s and architecture %s", name, upload.Version, upload.OS, upload.Architecture)})
+		ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("uploaded signed application '%s' version %s for OS %s and architecture %s", name, upload.Version, upload.OS, upload.Architecture), "sha256": sum})
 	} else {
-		ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("uploaded unsigned application '%s' version %s for OS %s and architecture %s", name, upload.Version, upload.OS, upload.Architecture)})
+		ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("uploaded unsigned application '%s' version %s for OS %s and architecture %s", name, upload.Version, upload.OS, upload.Architecture), "sha256": sum})
 	}
 }
 
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -84,3 +85,59 @@ func Benchmark_HealthHandler(b *testing.B) {
 		svc.HealthHandler(ctx)
 	}
 }
+
+func TestUpdate_GetLatestVersion(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.9", "v0.0.10", "v1.0.0-rc1"} {
+		if err := store.Put(newTestUpdate(v), strings.NewReader(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := newTestUpdate("v0.0.1").GetLatestVersion(store)
+	if err != nil || latest != "v1.0.0-rc1" {
+		t.Fatalf("Wrong latest version %s: %v", latest, err)
+	}
+
+	if err := store.Put(newTestUpdate("v1.0.0"), strings.NewReader("v1.0.0"), nil); err != nil {
+		t.Fatal(err)
+	}
+	latest, err = newTestUpdate("v0.0.1").GetLatestVersion(store)
+	if err != nil || latest != "v1.0.0" {
+		t.Fatalf("Wrong latest version %s: %v", latest, err)
+	}
+
+	if err := store.Put(newTestUpdate("latest"), strings.NewReader("latest"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// artifacts with invalid versions are skipped
+	latest, err = newTestUpdate("v0.0.1").GetLatestVersion(store)
+	if err != nil || latest != "v1.0.0" {
+		t.Fatalf("Wrong latest version with invalid artifact version %s: %v", latest, err)
+	}
+}
+
+func TestUpdate_GetLatestVersionChannel(t *testing.T) {
+	store := newMemStorage()
+	for v, channel := range map[string]string{"v1.0.0": "", "v1.1.0-beta.1": "beta", "v1.2.0-nightly.1": "nightly"} {
+		upload := &UploadData{Data: []byte(v), Version: v, Architecture: "amd64", OS: "linux", Channel: channel}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for channel, want := range map[string]string{"": "v1.0.0", "stable": "v1.0.0", "beta": "v1.1.0-beta.1", "nightly": "v1.2.0-nightly.1"} {
+		u := newTestUpdate("v0.0.1")
+		u.Channel = channel
+		latest, err := u.GetLatestVersion(store)
+		if err != nil || latest != want {
+			t.Errorf("Wrong latest version %s for channel %q, want %s: %v", latest, channel, want, err)
+		}
+	}
+
+	u := newTestUpdate("v0.0.1")
+	u.Channel = "unknown"
+	if _, err := u.GetLatestVersion(store); err == nil {
+		t.Fatal("Unknown channel not reported")
+	}
+}
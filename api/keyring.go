@@ -0,0 +1,138 @@
+package api
+
+import (
+	"crypto"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/conf"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+var (
+	errSignatureMissing = errors.New("Missing signature")
+	errSignatureInvalid = errors.New("Signature can not be verified by any trusted key")
+)
+
+// trustedKey is a public key trusted to sign releases.
+type trustedKey struct {
+	id  string
+	key crypto.PublicKey
+	pem []byte
+	// signedBy is the ID of the key, which cross-signed this key
+	signedBy       string
+	crossSignature []byte
+}
+
+// crossSignedKey is returned to clients to introduce a new key signed
+// by an older key, which the client already trusts.
+type crossSignedKey struct {
+	ID             string `json:"id"`
+	PublicKey      string `json:"public-key"`
+	SignedBy       string `json:"signed-by"`
+	CrossSignature []byte `json:"cross-signature"`
+}
+
+// keyring contains all keys trusted to sign releases of one
+// application.
+type keyring []*trustedKey
+
+// loadKeyring reads all configured public keys and verifies their
+// cross-signatures.
+func loadKeyring(keys []conf.PublicKey) (keyring, error) {
+	var kr keyring
+	for _, k := range keys {
+		b, err := ioutil.ReadFile(k.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key %s: %v", k.ID, err)
+		}
+		pub, err := signature.ParsePublicKeyPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", k.ID, err)
+		}
+		tk := &trustedKey{id: k.ID, key: pub, pem: b, signedBy: k.SignedBy}
+		if k.SignedBy != "" {
+			if tk.crossSignature, err = ioutil.ReadFile(k.CrossSignature); err != nil {
+				return nil, fmt.Errorf("failed to read cross-signature of public key %s: %v", k.ID, err)
+			}
+		}
+		kr = append(kr, tk)
+	}
+
+	for _, k := range kr {
+		if k.signedBy == "" {
+			continue
+		}
+		signer := kr.get(k.signedBy)
+		if signer == nil {
+			return nil, fmt.Errorf("public key %s is signed by unknown key %s", k.id, k.signedBy)
+		}
+		if err := signature.VerifyKey(signer.key, k.key, k.crossSignature); err != nil {
+			return nil, fmt.Errorf("failed to verify cross-signature of public key %s by %s: %v", k.id, k.signedBy, err)
+		}
+	}
+	return kr, nil
+}
+
+// loadKeyrings returns the keyrings of all configured applications,
+// which have public keys.
+func loadKeyrings(config *conf.Config) (map[string]keyring, error) {
+	keyrings := make(map[string]keyring)
+	for name, app := range config.Applications {
+		if len(app.PublicKeys) == 0 {
+			continue
+		}
+		kr, err := loadKeyring(app.PublicKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keyring of %s: %v", name, err)
+		}
+		keyrings[name] = kr
+	}
+	return keyrings, nil
+}
+
+func (kr keyring) get(id string) *trustedKey {
+	for _, k := range kr {
+		if k.id == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// chain returns the key id and all keys which cross-signed it
+// directly or indirectly, such that clients trusting any key of the
+// chain can verify the key id.
+func (kr keyring) chain(id string) []crossSignedKey {
+	var chain []crossSignedKey
+	seen := make(map[string]bool)
+	for k := kr.get(id); k != nil && k.signedBy != "" && !seen[k.id]; k = kr.get(k.signedBy) {
+		seen[k.id] = true
+		chain = append(chain, crossSignedKey{
+			ID:             k.id,
+			PublicKey:      string(k.pem),
+			SignedBy:       k.signedBy,
+			CrossSignature: k.crossSignature,
+		})
+	}
+	return chain
+}
+
+// verify returns the key, which verifies signature of the given
+// SHA256 digest.
+func (kr keyring) verify(signatureType string, digest, sig []byte) (*trustedKey, error) {
+	if len(sig) == 0 {
+		return nil, errSignatureMissing
+	}
+	verifier, err := signature.NewVerifier(signatureType)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range kr {
+		if err := verifier.VerifySignature(digest, sig, crypto.SHA256, k.key); err == nil {
+			return k, nil
+		}
+	}
+	return nil, errSignatureInvalid
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/szuecs/binary-patch/conf"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+// writeTestKey writes the PEM encoded public key of a new ECDSA key to
+// dir and returns the private key.
+func writeTestKey(t *testing.T, dir, id string) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, id+".pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func signTestData(t *testing.T, priv *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestUploadHandler_verifySignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trusted := writeTestKey(t, dir, "trusted")
+	untrusted := writeTestKey(t, dir, "untrusted")
+
+	kr, err := loadKeyring([]conf.PublicKey{{ID: "trusted", Path: filepath.Join(dir, "trusted.pem")}})
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+	svc := &Service{Healthy: true, Storage: newMemStorage(), keyrings: map[string]keyring{"foo": kr}}
+
+	data := []byte("binary")
+	for _, tc := range []struct {
+		version   string
+		signature []byte
+		want      int
+	}{
+		{"v0.0.1", signTestData(t, trusted, data), 200},
+		{"v0.0.2", signTestData(t, untrusted, data), 422},
+		{"v0.0.3", signTestData(t, trusted, []byte("other binary")), 422},
+		{"v0.0.4", nil, 422},
+	} {
+		upload := UploadData{Data: data, Version: tc.version, Architecture: "amd64", OS: "linux", Signature: tc.signature, SignatureType: "ecdsa"}
+		body, _ := json.Marshal(upload)
+		ctx, w := newTestRequestContext("PUT", "/upload/foo", string(body))
+		svc.UploadHandler(ctx)
+		if w.Code != tc.want {
+			t.Errorf("Wrong status code %d for %s, want %d: %s", w.Code, tc.version, tc.want, w.Body.String())
+		}
+	}
+}
+
+func TestLoadKeyring_crossSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldKey := writeTestKey(t, dir, "old")
+	newKey := writeTestKey(t, dir, "new")
+
+	digest, err := signature.KeyDigest(&newKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossSig, err := ecdsa.SignASN1(rand.Reader, oldKey, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossSigPath := filepath.Join(dir, "new.cross-signature")
+	if err := ioutil.WriteFile(crossSigPath, crossSig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys := []conf.PublicKey{
+		{ID: "old", Path: filepath.Join(dir, "old.pem")},
+		{ID: "new", Path: filepath.Join(dir, "new.pem"), SignedBy: "old", CrossSignature: crossSigPath},
+	}
+	kr, err := loadKeyring(keys)
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+	if chain := kr.chain("old"); len(chain) != 0 {
+		t.Fatalf("Wrong chain of old key: %v", chain)
+	}
+	chain := kr.chain("new")
+	if len(chain) != 1 || chain[0].ID != "new" || chain[0].SignedBy != "old" {
+		t.Fatalf("Wrong chain of new key: %v", chain)
+	}
+
+	svc := &Service{Healthy: true, Storage: newMemStorage(), keyrings: map[string]keyring{"foo": kr}}
+	data := []byte("binary")
+	upload := UploadData{Data: data, Version: "v0.0.1", Architecture: "amd64", OS: "linux", Signature: signTestData(t, newKey, data), SignatureType: "ecdsa"}
+	body, _ := json.Marshal(upload)
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", string(body))
+	svc.UploadHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Failed to upload: %d %s", w.Code, w.Body.String())
+	}
+	ctx, w = newTestContext("/signed-update/foo?version=v0.0.0&arch=amd64&os=linux")
+	svc.SignedUpdateHandler(ctx)
+	var resp struct {
+		KeyID    string           `json:"key-id"`
+		KeyChain []crossSignedKey `json:"key-chain"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", w.Body.String(), err)
+	}
+	if resp.KeyID != "new" || len(resp.KeyChain) != 1 || resp.KeyChain[0].SignedBy != "old" {
+		t.Fatalf("Wrong signing key in response: %s", w.Body.String())
+	}
+
+	keys[1].CrossSignature = filepath.Join(dir, "old.pem")
+	if _, err := loadKeyring(keys); err == nil {
+		t.Fatal("Loaded keyring with invalid cross-signature")
+	}
+}
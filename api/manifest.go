@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/conf"
+	"github.com/szuecs/binary-patch/semver"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+// defaultManifestExpiry is the lifetime of a manifest, if not
+// configured otherwise.
+const defaultManifestExpiry = 24 * time.Hour
+
+var errManifestDisabled = errors.New("Manifest signing key is not configured")
+
+// Manifest lists all releases of an application. It is signed by the
+// server and expires, such that clients can detect a server or mirror,
+// which withholds updates or serves outdated releases.
+type Manifest struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Expires   time.Time `json:"expires"`
+	// Channels are the release channels of the application ordered
+	// from most to least stable.
+	Channels []string          `json:"channels,omitempty"`
+	Releases []ManifestRelease `json:"releases"`
+}
+
+// ManifestRelease is one artifact listed in a Manifest.
+type ManifestRelease struct {
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	OS      string `json:"os"`
+	Channel string `json:"channel"`
+	Rollout int    `json:"rollout"`
+	// SHA256 is the hex encoded digest of the artifact
+	SHA256 string `json:"sha256"`
+	// RollbackFrom is set, if releases newer than this one up to
+	// RollbackFrom are rolled back to this release.
+	RollbackFrom string `json:"rollback-from,omitempty"`
+	// State is yanked, deprecated or paused, empty for active releases.
+	State string `json:"state,omitempty"`
+}
+
+// SignedManifest is the manifest sent to clients. Signature is
+// created over the SHA256 digest of the exact bytes of Signed, which
+// is the JSON encoded Manifest.
+type SignedManifest struct {
+	Signed        json.RawMessage `json:"signed"`
+	Signature     []byte          `json:"signature"`
+	SignatureType string          `json:"signature-type"`
+}
+
+// loadManifestKey returns the configured private key used to sign
+// manifests or nil, if manifests are disabled.
+func loadManifestKey(config *conf.Config) (crypto.Signer, error) {
+	if config.ManifestKeyPath == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(config.ManifestKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest key: %v", err)
+	}
+	key, err := signature.ParsePrivateKeyPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest key: %v", err)
+	}
+	return key, nil
+}
+
+func manifestExpiry() time.Duration {
+	if cfg == nil || cfg.ManifestExpiry <= 0 {
+		return defaultManifestExpiry
+	}
+	return cfg.ManifestExpiry
+}
+
+// manifest returns the manifest of all releases of application name.
+func (svc *Service) manifest(name string, now time.Time) (*Manifest, error) {
+	m := &Manifest{
+		Name:      name,
+		Timestamp: now.UTC(),
+		Expires:   now.Add(manifestExpiry()).UTC(),
+		Channels:  channelsOf(name),
+		Releases:  []ManifestRelease{},
+	}
+	for system := range supported {
+		versions, err := svc.Storage.Versions(name, system)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			u := &Update{Name: name, Version: v, System: system}
+			if _, err := semver.Parse(v); err != nil {
+				glog.Warningf("Skip artifact %s with invalid version in manifest: %v", u, err)
+				continue
+			}
+			digest, err := readSidecar(svc.Storage, u, sidecarSHA256)
+			if err != nil {
+				glog.Warningf("Skip artifact %s without digest in manifest: %v", u, err)
+				continue
+			}
+			release, err := readRelease(svc.Storage, u)
+			if err != nil {
+				return nil, err
+			}
+			mr := ManifestRelease{
+				Version: v,
+				Arch:    system.Arch,
+				OS:      system.OS,
+				Channel: release.Channel,
+				Rollout: release.Rollout,
+				SHA256:  strings.TrimSpace(string(digest)),
+				State:   release.State,
+			}
+			if release.Rollback != nil {
+				mr.RollbackFrom = release.Rollback.From
+			}
+			m.Releases = append(m.Releases, mr)
+		}
+	}
+	sort.Slice(m.Releases, func(i, j int) bool {
+		a, b := m.Releases[i], m.Releases[j]
+		if a.Arch+a.OS != b.Arch+b.OS {
+			return a.Arch+a.OS < b.Arch+b.OS
+		}
+		c, err := semver.Compare(a.Version, b.Version)
+		return err == nil && c < 0
+	})
+	return m, nil
+}
+
+// signManifest signs the JSON encoded manifest m with the manifest key.
+func (svc *Service) signManifest(m *Manifest) (*SignedManifest, error) {
+	if svc.manifestKey == nil {
+		return nil, errManifestDisabled
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(b)
+	sig, signatureType, err := signature.Sign(svc.manifestKey, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign manifest of %s: %v", m.Name, err)
+	}
+	return &SignedManifest{Signed: b, Signature: sig, SignatureType: signatureType}, nil
+}
+
+// ManifestHandler handles /manifest/:name endpoint and returns the
+// signed manifest of the application.
+func (svc *Service) ManifestHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+	if svc.manifestKey == nil {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": errManifestDisabled.Error()})
+		return
+	}
+	m, err := svc.manifest(name, time.Now())
+	if err != nil {
+		glog.Errorf("Failed to create manifest of %s: %v", name, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create manifest of application '%s'", name)})
+		return
+	}
+	if len(m.Releases) == 0 {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' not found", name)})
+		return
+	}
+	signed, err := svc.signManifest(m)
+	if err != nil {
+		glog.Errorf("Failed to sign manifest of %s: %v", name, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to sign manifest of application '%s'", name)})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, signed)
+}
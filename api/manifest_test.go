@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestManifestHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.10", "v0.0.9"} {
+		sidecars := map[string][]byte{sidecarSHA256: []byte("digest " + v + "\n")}
+		if err := store.Put(newTestUpdate(v), strings.NewReader("binary "+v), sidecars); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	ctx, w := newTestContext("/manifest/foo")
+	svc.ManifestHandler(ctx)
+	if w.Code != 404 {
+		t.Fatalf("Wrong status code %d without manifest key", w.Code)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.manifestKey = key
+	ctx, w = newTestContext("/manifest/foo")
+	svc.ManifestHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong status code %d: %s", w.Code, w.Body.String())
+	}
+
+	var signed SignedManifest
+	if err := json.Unmarshal(w.Body.Bytes(), &signed); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", w.Body.String(), err)
+	}
+	digest := sha256.Sum256(signed.Signed)
+	if err := signature.Verify(signed.SignatureType, &key.PublicKey, digest[:], signed.Signature); err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(signed.Signed, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "foo" || !m.Expires.After(time.Now().Add(defaultManifestExpiry-time.Minute)) {
+		t.Fatalf("Wrong manifest: %+v", m)
+	}
+	if len(m.Releases) != 2 || m.Releases[0].Version != "v0.0.9" || m.Releases[1].SHA256 != "digest v0.0.10" {
+		t.Fatalf("Wrong releases: %+v", m.Releases)
+	}
+}
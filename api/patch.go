@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kr/binarydist"
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/semver"
+)
+
+// patchExt returns the sidecar extension of the target artifact,
+// which caches the binary patch from version from.
+func patchExt(from string) string {
+	return from + ".patch"
+}
+
+// flightCall is an in-flight or completed call of a flightGroup.
+type flightCall struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+}
+
+// flightGroup deduplicates concurrent calls with the same key, such
+// that only one of them does the work and all get the same result.
+// The zero value is ready to use.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.val, c.err
+}
+
+// getPatch returns the binary patch from the artifact of from to the
+// artifact of to. Patches are cached as sidecar of the target
+// artifact, keyed by name, from-version, to-version, arch and os. A
+// missing patch is generated once, even if requested concurrently.
+func (svc *Service) getPatch(from, to *Update) ([]byte, error) {
+	ext := patchExt(from.Version)
+	patch, ok, err := svc.cachedPatch(to, ext)
+	if ok || err != nil {
+		return patch, err
+	}
+
+	return svc.patches.do(to.String()+"."+ext, func() ([]byte, error) {
+		// an earlier call may have cached the patch after the lookup
+		patch, ok, err := svc.cachedPatch(to, ext)
+		if ok || err != nil {
+			return patch, err
+		}
+		patch, err = svc.createPatch(from, to)
+		if err != nil {
+			return nil, err
+		}
+		if err := svc.Storage.PutSidecar(to, ext, patch); err != nil {
+			// serving the patch is still possible
+			glog.Errorf("Failed to cache patch %s.%s: %v", to, ext, err)
+		}
+		return patch, nil
+	})
+}
+
+// cachedPatch returns the patch cached as sidecar ext of to and true,
+// or false if it is not cached.
+func (svc *Service) cachedPatch(to *Update, ext string) ([]byte, bool, error) {
+	patch, err := readSidecar(svc.Storage, to, ext)
+	if err == nil {
+		glog.V(2).Infof("Use cached patch %s.%s", to, ext)
+		return patch, true, nil
+	}
+	if errors.Cause(err) != errBinaryNotFound {
+		return nil, false, err
+	}
+	return nil, false, nil
+}
+
+func (svc *Service) createPatch(from, to *Update) ([]byte, error) {
+	rcOld, err := svc.Storage.Open(from)
+	if err != nil {
+		return nil, err
+	}
+	defer rcOld.Close()
+	rcNew, err := svc.Storage.Open(to)
+	if err != nil {
+		return nil, err
+	}
+	defer rcNew.Close()
+
+	glog.Infof("Create patch old: %v, new: %v", from, to)
+	var buf bytes.Buffer
+	if err := binarydist.Diff(rcOld, rcNew, &buf); err != nil {
+		return nil, fmt.Errorf("failed to create a binary patch for %s: %v", to.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// precomputePatches creates the patches from the last n versions
+// before u to u.
+func (svc *Service) precomputePatches(u *Update, n int) {
+	if n <= 0 {
+		return
+	}
+	versions, err := svc.Storage.Versions(u.Name, u.System)
+	if err != nil {
+		glog.Errorf("Failed to precompute patches to %s: %v", u, err)
+		return
+	}
+	target, err := semver.Parse(u.Version)
+	if err != nil {
+		glog.Errorf("Failed to precompute patches to %s: %v", u, err)
+		return
+	}
+
+	type version struct {
+		s  string
+		sv *semver.Version
+	}
+	var older []version
+	for _, v := range versions {
+		sv, err := semver.Parse(v)
+		if err != nil || sv.Compare(target) >= 0 {
+			continue
+		}
+		older = append(older, version{s: v, sv: sv})
+	}
+	sort.Slice(older, func(i, j int) bool {
+		return older[i].sv.Compare(older[j].sv) > 0
+	})
+	if len(older) > n {
+		older = older[:n]
+	}
+
+	for _, v := range older {
+		from := u.Clone()
+		from.Version = v.s
+		if _, err := svc.getPatch(from, u); err != nil {
+			glog.Errorf("Failed to precompute patch from %s to %s: %v", from, u, err)
+		}
+	}
+}
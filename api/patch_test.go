@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kr/binarydist"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := g.do("key", func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return []byte("patch"), nil
+			})
+			if err != nil || string(b) != "patch" {
+				t.Errorf("Wrong result %s: %v", b, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Function called %d times", calls)
+	}
+}
+
+func TestService_getPatch(t *testing.T) {
+	store := newMemStorage()
+	binaries := map[string]string{
+		"v0.0.1": strings.Repeat("old binary ", 100),
+		"v0.0.2": strings.Repeat("new binary ", 100),
+		"v0.0.3": strings.Repeat("newer binary ", 100),
+	}
+	for v, b := range binaries {
+		if err := store.Put(newTestUpdate(v), strings.NewReader(b), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	from, to := newTestUpdate("v0.0.1"), newTestUpdate("v0.0.2")
+	patch, err := svc.getPatch(from, to)
+	if err != nil {
+		t.Fatalf("Failed to get patch: %v", err)
+	}
+	var patched bytes.Buffer
+	if err := binarydist.Patch(strings.NewReader(binaries["v0.0.1"]), &patched, bytes.NewReader(patch)); err != nil {
+		t.Fatalf("Failed to apply patch: %v", err)
+	}
+	if patched.String() != binaries["v0.0.2"] {
+		t.Fatal("Patched binary differs from new binary")
+	}
+	cached, err := readSidecar(store, to, patchExt(from.Version))
+	if err != nil || !bytes.Equal(cached, patch) {
+		t.Fatalf("Patch not cached: %v", err)
+	}
+
+	svc.precomputePatches(newTestUpdate("v0.0.3"), 1)
+	if _, err := readSidecar(store, newTestUpdate("v0.0.3"), patchExt("v0.0.2")); err != nil {
+		t.Fatalf("Patch from v0.0.2 not precomputed: %v", err)
+	}
+	if _, err := readSidecar(store, newTestUpdate("v0.0.3"), patchExt("v0.0.1")); err == nil {
+		t.Fatal("Patch from v0.0.1 precomputed")
+	}
+}
+
+// lateCacheStorage caches a patch right after the first lookup of it
+// missed, like a concurrent call finishing.
+type lateCacheStorage struct {
+	*memStorage
+	missed bool
+}
+
+func (ls *lateCacheStorage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	if !ls.missed && strings.HasSuffix(ext, ".patch") {
+		ls.missed = true
+		rc, err := ls.memStorage.OpenSidecar(u, ext)
+		ls.memStorage.PutSidecar(u, ext, []byte("cached patch"))
+		return rc, err
+	}
+	return ls.memStorage.OpenSidecar(u, ext)
+}
+
+func TestService_getPatchCachedLate(t *testing.T) {
+	store := &lateCacheStorage{memStorage: newMemStorage()}
+	for _, v := range []string{"v0.0.1", "v0.0.2"} {
+		if err := store.Put(newTestUpdate(v), strings.NewReader("binary "+v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+	patch, err := svc.getPatch(newTestUpdate("v0.0.1"), newTestUpdate("v0.0.2"))
+	if err != nil || string(patch) != "cached patch" {
+		t.Fatalf("Patch created again instead of using the cache: %v", err)
+	}
+}
+
+func TestSignedPatchUpdateHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.1", "v0.0.2"} {
+		upload := &UploadData{Data: []byte(strings.Repeat("binary "+v, 10)), Version: v, Architecture: "amd64", OS: "linux", Signature: []byte("sig"), SignatureType: "ed25519"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	ctx, w := newTestContext("/signed-patch-update/foo?version=v0.0.1&arch=amd64&os=linux")
+	svc.SignedPatchUpdateHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Fatalf("Wrong Content-Length %s for %d bytes", w.Header().Get("Content-Length"), w.Body.Len())
+	}
+	var data struct {
+		Patch         []byte `json:"patch"`
+		SignatureType string `json:"signature-type"`
+		PatchDigest   string `json:"patch-sha256"`
+		FromDigest    string `json:"from-sha256"`
+		ToDigest      string `json:"to-sha256"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.SignatureType != "ed25519" {
+		t.Fatalf("Wrong signature type %s", data.SignatureType)
+	}
+	if data.PatchDigest != fmt.Sprintf("%x", sha256.Sum256(data.Patch)) {
+		t.Fatalf("Wrong patch digest %s", data.PatchDigest)
+	}
+	if data.FromDigest != fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Repeat("binary v0.0.1", 10)))) {
+		t.Fatalf("Wrong from digest %s", data.FromDigest)
+	}
+	if data.ToDigest != fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Repeat("binary v0.0.2", 10)))) {
+		t.Fatalf("Wrong to digest %s", data.ToDigest)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/conf"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+const sidecarRelease = "release"
+
+var errUnknownChannel = errors.New("Unknown channel: ")
+
+// Release is the metadata of an artifact, which is stored as JSON in
+// the release sidecar. Artifacts without release sidecar, p.e. created
+// by copying files, are treated as stable releases.
+type Release struct {
+	// Channel is the release channel the artifact was published to.
+	Channel string `json:"channel"`
+	// Rollout is the percentage of clients, which are offered the
+	// release.
+	Rollout int `json:"rollout"`
+	// SignatureType is the type of the signature sidecar, p.e. ecdsa,
+	// ed25519 or rsa-pss.
+	SignatureType string `json:"signature-type,omitempty"`
+	// KeyID is the ID of the key, which created the signature.
+	KeyID string `json:"key-id,omitempty"`
+	// Rollback is set, if clients running newer releases are
+	// downgraded to this release.
+	Rollback *Rollback `json:"rollback,omitempty"`
+	// State is yanked, deprecated or paused, empty for active releases.
+	State string `json:"state,omitempty"`
+	// ReleaseNotesURL links to the release notes shown to users.
+	ReleaseNotesURL string `json:"release-notes-url,omitempty"`
+	// Mandatory releases should not be skipped by clients.
+	Mandatory bool `json:"mandatory,omitempty"`
+}
+
+// signatureType returns the type of the signature sidecar. Releases
+// stored before the type was recorded are signed by ECDSA.
+func (r *Release) signatureType() string {
+	if r.SignatureType == "" {
+		return signature.ECDSA
+	}
+	return r.SignatureType
+}
+
+func defaultRelease(application string) *Release {
+	return &Release{
+		Channel: channelsOf(application)[0],
+		Rollout: 100,
+	}
+}
+
+// readRelease returns the release metadata of the artifact of u.
+func readRelease(store Storage, u *Update) (*Release, error) {
+	b, err := readSidecar(store, u, sidecarRelease)
+	if err != nil {
+		if errors.Cause(err) == errBinaryNotFound {
+			return defaultRelease(u.Name), nil
+		}
+		return nil, err
+	}
+	release := defaultRelease(u.Name)
+	if err := json.Unmarshal(b, release); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal release of %s: %v", u, err)
+	}
+	return release, nil
+}
+
+func (r *Release) marshal() []byte {
+	var buf bytes.Buffer
+	// can not fail for this struct
+	json.NewEncoder(&buf).Encode(r)
+	return buf.Bytes()
+}
+
+// channelsOf returns the channels of application ordered from most to
+// least stable.
+func channelsOf(application string) []string {
+	if cfg == nil {
+		return conf.DefaultChannels
+	}
+	return cfg.Channels(application)
+}
+
+// channelRank returns the position of channel in the channels of
+// application, such that lower ranks are more stable.
+func channelRank(application, channel string) (int, error) {
+	for i, c := range channelsOf(application) {
+		if c == channel {
+			return i, nil
+		}
+	}
+	return 0, errors.Wrap(errUnknownChannel, channel)
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/szuecs/binary-patch/semver"
+)
+
+// Outcomes of an update reported by clients
+const (
+	outcomeSuccess         = "success"
+	outcomeChecksumFailure = "checksum-failure"
+	outcomeRollback        = "rollback"
+	outcomeFailure         = "failure"
+)
+
+// ReportData is the outcome of an update reported by a client.
+type ReportData struct {
+	InstallID     string `json:"install-id,omitempty"`     // ID of the installation
+	Version       string `json:"version"`                  // version running after the update
+	TargetVersion string `json:"target-version,omitempty"` // version the client updated to, defaults to Version
+	Architecture  string `json:"arch,omitempty"`           // architecture, p.e. amd64
+	OS            string `json:"os,omitempty"`             // operating system, p.e. linux
+	Outcome       string `json:"outcome"`                  // success, checksum-failure, rollback or failure
+	Error         string `json:"error,omitempty"`          // error message of failed updates
+}
+
+// VersionReport summarizes the reports of clients about one version.
+type VersionReport struct {
+	Version string `json:"version"`
+	// Installs is the number of installations, which run the
+	// version as far as known
+	Installs int `json:"installs"`
+	// Outcomes counts the reported outcomes of updates to the
+	// version
+	Outcomes map[string]int `json:"outcomes,omitempty"`
+}
+
+// Limits of the reports kept per application
+const (
+	// maxReportedInstalls is the maximum number of installations
+	// per application, the least recently seen are dropped first
+	maxReportedInstalls = 100000
+	// reportExpiry is the time after which an installation, which was
+	// not seen anymore, is dropped
+	reportExpiry = 30 * 24 * time.Hour
+)
+
+// reportStore aggregates the versions clients run and the outcomes of
+// their updates in memory, such that adoption and broken releases
+// can be seen.
+type reportStore struct {
+	mu sync.Mutex
+	// running maps application and install ID to the running version
+	running map[string]map[string]installation
+	// outcomes maps application, version and outcome to the number
+	// of reports
+	outcomes map[string]map[string]map[string]int
+	// recent maps application, version and install ID to the latest
+	// report within the window of the rollout halt
+	recent map[string]map[string]map[string]reportEvent
+	// halts are the rollouts halted automatically
+	halts []RolloutHalt
+}
+
+// installation is the version an installation ran, when it was seen
+// the last time.
+type installation struct {
+	version string
+	seen    time.Time
+}
+
+// reportEvent is an update report at a point in time.
+type reportEvent struct {
+	time   time.Time
+	failed bool
+}
+
+// seen records the version an installation runs at now. Clients
+// without install ID are not counted.
+func (rs *reportStore) seen(name, installID, version string, now time.Time) {
+	if installID == "" {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.running == nil {
+		rs.running = make(map[string]map[string]installation)
+	}
+	if rs.running[name] == nil {
+		rs.running[name] = make(map[string]installation)
+	}
+	installs := rs.running[name]
+	if _, ok := installs[installID]; !ok && len(installs) >= maxReportedInstalls {
+		expire(installs, now)
+	}
+	installs[installID] = installation{version: version, seen: now}
+}
+
+// expire drops the installations not seen within reportExpiry and, if
+// there are still maxReportedInstalls, the least recently seen one.
+func expire(installs map[string]installation, now time.Time) {
+	oldestID, oldest := "", now
+	for id, in := range installs {
+		if now.Sub(in.seen) > reportExpiry {
+			delete(installs, id)
+			continue
+		}
+		if in.seen.Before(oldest) {
+			oldestID, oldest = id, in.seen
+		}
+	}
+	if len(installs) >= maxReportedInstalls {
+		delete(installs, oldestID)
+	}
+}
+
+// add records the outcome of an update of application name at now.
+func (rs *reportStore) add(name string, r *ReportData, now time.Time) {
+	rs.seen(name, r.InstallID, r.Version, now)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.outcomes == nil {
+		rs.outcomes = make(map[string]map[string]map[string]int)
+	}
+	if rs.outcomes[name] == nil {
+		rs.outcomes[name] = make(map[string]map[string]int)
+	}
+	if rs.outcomes[name][r.TargetVersion] == nil {
+		rs.outcomes[name][r.TargetVersion] = make(map[string]int)
+	}
+	rs.outcomes[name][r.TargetVersion][r.Outcome]++
+}
+
+// seen records the version the client of u runs, if it is a stored
+// version of the application, such that clients can not fill the
+// report store with unknown applications and versions.
+func (svc *Service) seen(u *Update) {
+	if u.InstallID == "" {
+		return
+	}
+	versions, err := svc.Storage.Versions(u.Name, u.System)
+	if err != nil {
+		glog.Errorf("Could not list versions of %s, caused by: %v", u, err)
+		return
+	}
+	for _, v := range versions {
+		if v == u.Version {
+			svc.reports.seen(u.Name, u.InstallID, u.Version, time.Now())
+			return
+		}
+	}
+}
+
+// hasVersion returns true, if application name has an artifact of
+// version for any supported system.
+func (svc *Service) hasVersion(name, version string) (bool, error) {
+	for system := range supported {
+		versions, err := svc.Storage.Versions(name, system)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range versions {
+			if v == version {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// summary returns the reports of all versions of application name,
+// the newest first.
+func (rs *reportStore) summary(name string) []*VersionReport {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	byVersion := make(map[string]*VersionReport)
+	get := func(v string) *VersionReport {
+		vr, ok := byVersion[v]
+		if !ok {
+			vr = &VersionReport{Version: v}
+			byVersion[v] = vr
+		}
+		return vr
+	}
+	for _, in := range rs.running[name] {
+		get(in.version).Installs++
+	}
+	for v, outcomes := range rs.outcomes[name] {
+		vr := get(v)
+		vr.Outcomes = make(map[string]int, len(outcomes))
+		for outcome, n := range outcomes {
+			vr.Outcomes[outcome] = n
+		}
+	}
+
+	reports := make([]*VersionReport, 0, len(byVersion))
+	for _, vr := range byVersion {
+		reports = append(reports, vr)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		c, err := semver.Compare(reports[i].Version, reports[j].Version)
+		if err != nil {
+			return reports[i].Version > reports[j].Version
+		}
+		return c > 0
+	})
+	return reports
+}
+
+// ReportHandler handles POST /report/:name endpoint, where clients
+// report the outcome of an update. A release is paused, if too many
+// clients report failed updates, see checkRollout.
+func (svc *Service) ReportHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+
+	var data ReportData
+	if err := ginCtx.BindJSON(&data); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)})
+		return
+	}
+	if _, err := semver.Parse(data.Version); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Invalid version of application '%s': %v", name, err)})
+		return
+	}
+	if data.TargetVersion == "" {
+		data.TargetVersion = data.Version
+	}
+	if _, err := semver.Parse(data.TargetVersion); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Invalid target version of application '%s': %v", name, err)})
+		return
+	}
+	switch data.Outcome {
+	case outcomeSuccess, outcomeChecksumFailure, outcomeRollback, outcomeFailure:
+	default:
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Invalid outcome %q, has to be one of %s, %s, %s or %s", data.Outcome, outcomeSuccess, outcomeChecksumFailure, outcomeRollback, outcomeFailure)})
+		return
+	}
+
+	for _, v := range []string{data.Version, data.TargetVersion} {
+		ok, err := svc.hasVersion(name, v)
+		if err != nil {
+			glog.Errorf("Failed to find %s %s: %v", name, v, err)
+			ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to find application '%s' version %s", name, v)})
+			return
+		}
+		if !ok {
+			ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' version %s not found", name, v)})
+			return
+		}
+	}
+
+	now := time.Now()
+	svc.reports.add(name, &data, now)
+	failed := data.Outcome != outcomeSuccess
+	if failed {
+		glog.Warningf("Client %s of %s %s reported %s of update to %s on %s/%s: %s", data.InstallID, name, data.Version, data.Outcome, data.TargetVersion, data.Architecture, data.OS, data.Error)
+	}
+	svc.checkRollout(name, data.TargetVersion, data.InstallID, failed, now)
+	ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("reported %s of application '%s' version %s", data.Outcome, name, data.TargetVersion)})
+}
+
+// ReportsHandler handles GET /report/:name endpoint and returns the
+// installations and update outcomes of all versions of the
+// application, the newest first.
+func (svc *Service) ReportsHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+	ginCtx.JSON(http.StatusOK, gin.H{"name": name, "versions": svc.reports.summary(name)})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReportHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.1", "v0.0.2"} {
+		if err := store.Put(newTestUpdate(v), strings.NewReader("binary "+v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+	for _, id := range []string{"a", "b"} {
+		u := newTestUpdate("v0.0.1")
+		u.InstallID = id
+		svc.seen(u)
+	}
+	// unknown applications and versions are not counted
+	unknown := newTestUpdate("v0.0.9")
+	unknown.InstallID = "x"
+	svc.seen(unknown)
+	unknown.Name = "bar"
+	svc.seen(unknown)
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"install-id": "a", "version": "v0.0.2", "outcome": "success"}`, 200},
+		{`{"install-id": "b", "version": "v0.0.1", "target-version": "v0.0.2", "outcome": "rollback", "error": "crashed"}`, 200},
+		{`{"install-id": "c", "version": "v0.0.1", "target-version": "v0.0.2", "outcome": "checksum-failure"}`, 200},
+		{`{"version": "v0.0.1", "outcome": "exploded"}`, 422},
+		{`{"version": "latest", "outcome": "success"}`, 422},
+		{`{"install-id": "d", "version": "v0.0.1", "target-version": "v0.0.3", "outcome": "failure"}`, 404},
+	} {
+		ctx, w := newTestRequestContext("POST", "/report/foo", tc.body)
+		svc.ReportHandler(ctx)
+		if w.Code != tc.want {
+			t.Fatalf("Wrong response for %s: %d: %s", tc.body, w.Code, w.Body.String())
+		}
+	}
+
+	ctx, w := newTestRequestContext("GET", "/report/foo", "")
+	svc.ReportsHandler(ctx)
+	var resp struct {
+		Versions []VersionReport `json:"versions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Versions) != 2 {
+		t.Fatalf("Wrong versions %+v", resp.Versions)
+	}
+	v2, v1 := resp.Versions[0], resp.Versions[1]
+	if v2.Version != "v0.0.2" || v2.Installs != 1 || v2.Outcomes[outcomeSuccess] != 1 || v2.Outcomes[outcomeRollback] != 1 || v2.Outcomes[outcomeChecksumFailure] != 1 {
+		t.Fatalf("Wrong report of v0.0.2 %+v", v2)
+	}
+	if v1.Version != "v0.0.1" || v1.Installs != 2 || len(v1.Outcomes) != 0 {
+		t.Fatalf("Wrong report of v0.0.1 %+v", v1)
+	}
+	if len(svc.reports.running) != 1 {
+		t.Fatalf("Unknown application counted: %v", svc.reports.running)
+	}
+
+	// reports of unknown applications are rejected
+	ctx, w = newTestRequestContext("POST", "/report/bar", `{"version": "v0.0.1", "outcome": "success"}`)
+	ctx.Params = gin.Params{{Key: "name", Value: "bar"}}
+	svc.ReportHandler(ctx)
+	if w.Code != 404 {
+		t.Fatalf("Wrong response for unknown application %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	installs := map[string]installation{
+		"old":   {version: "v0.0.1", seen: now.Add(-2 * reportExpiry)},
+		"fresh": {version: "v0.0.1", seen: now},
+	}
+	expire(installs, now)
+	if _, ok := installs["old"]; ok || len(installs) != 1 {
+		t.Fatalf("Wrong installations after expiry: %v", installs)
+	}
+}
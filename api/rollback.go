@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/semver"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+// Rollback is stored in the release metadata of the target of a
+// rollback. Clients running a version newer than the target up to
+// From are downgraded to the target, if there is no newer release.
+// Releases in this range are not offered anymore.
+type Rollback struct {
+	From string `json:"from"`
+	// Signature of signature.RollbackDigest, which clients verify
+	// before they downgrade.
+	Signature     []byte `json:"signature"`
+	SignatureType string `json:"signature-type"`
+	// KeyID is the ID of the key, which created the signature, if
+	// the server verified it.
+	KeyID string `json:"key-id,omitempty"`
+}
+
+// covers returns true, if v is in the range of releases replaced by
+// the rollback to version to.
+func (rb *Rollback) covers(to, v *semver.Version) bool {
+	from, err := semver.Parse(rb.From)
+	if err != nil {
+		return false
+	}
+	return v.Compare(to) > 0 && v.Compare(from) <= 0
+}
+
+// RollbackData marks a previous release as the target for clients
+// running a newer, bad release. The downgrade authorization is signed
+// over the digest of the target binary, so every platform is rolled
+// back separately.
+type RollbackData struct {
+	Version       string `json:"version"`        // version of the target release
+	From          string `json:"from"`           // newest version, which is rolled back
+	Architecture  string `json:"arch"`           // architecture, p.e. amd64
+	OS            string `json:"os"`             // operating system, p.e. linux
+	Signature     []byte `json:"signature"`      // signature of the downgrade authorization
+	SignatureType string `json:"signature-type"` // ecdsa, ed25519 or rsa-pss, defaults to ecdsa
+}
+
+// RollbackHandler handles /rollback/:name endpoint
+func (svc *Service) RollbackHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+
+	var rollback RollbackData
+	if err := ginCtx.BindJSON(&rollback); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)})
+		return
+	}
+	c, err := semver.Compare(rollback.Version, rollback.From)
+	if err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if c >= 0 {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Rollback target %s has to be older than %s", rollback.Version, rollback.From)})
+		return
+	}
+	if rollback.SignatureType == "" {
+		rollback.SignatureType = signature.ECDSA
+	}
+	if !signature.Valid(rollback.SignatureType) {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Unknown signature type %q", rollback.SignatureType)})
+		return
+	}
+	if len(rollback.Signature) == 0 {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": errSignatureMissing.Error()})
+		return
+	}
+	u := &Update{Name: name, Version: rollback.Version, System: ArchAndOS{Arch: rollback.Architecture, OS: rollback.OS}}
+	if !u.isSupported() {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": errUnsupportedArchOS.Error()})
+		return
+	}
+
+	digest, err := readSidecar(svc.Storage, u, sidecarSHA256)
+	if errors.Cause(err) == errBinaryNotFound {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' version %s for %s/%s not found", name, rollback.Version, rollback.Architecture, rollback.OS)})
+		return
+	}
+	if err != nil {
+		glog.Errorf("Failed to read digest of %s: %v", u, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to roll back application '%s' to version %s", name, rollback.Version)})
+		return
+	}
+
+	// applications without keyring rely on the verification by clients
+	var keyID string
+	if kr, ok := svc.keyrings[name]; ok {
+		rbDigest := signature.RollbackDigest(name, rollback.From, rollback.Version, strings.TrimSpace(string(digest)))
+		key, err := kr.verify(rollback.SignatureType, rbDigest, rollback.Signature)
+		if err != nil {
+			ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to verify rollback of application '%s': %v", name, err)})
+			return
+		}
+		keyID = key.id
+	}
+
+	release, err := readRelease(svc.Storage, u)
+	if err == nil {
+		release.Rollback = &Rollback{
+			From:          rollback.From,
+			Signature:     rollback.Signature,
+			SignatureType: rollback.SignatureType,
+			KeyID:         keyID,
+		}
+		err = svc.Storage.PutSidecar(u, sidecarRelease, release.marshal())
+	}
+	if err != nil {
+		glog.Errorf("Failed to roll back %s to %s: %v", name, rollback.Version, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to roll back application '%s' to version %s", name, rollback.Version)})
+		return
+	}
+	glog.Infof("Rolled back %s up to %s to %s on %s/%s", name, rollback.From, rollback.Version, rollback.Architecture, rollback.OS)
+	ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("rolled back application '%s' up to version %s to version %s on %s/%s", name, rollback.From, rollback.Version, rollback.Architecture, rollback.OS)})
+}
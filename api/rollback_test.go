@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/szuecs/binary-patch/conf"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestRollbackHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := writeTestKey(t, dir, "release")
+	kr, err := loadKeyring([]conf.PublicKey{{ID: "release", Path: filepath.Join(dir, "release.pem")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.1", "v0.0.2", "v0.0.3"} {
+		upload := &UploadData{Data: []byte(v), Version: v, Architecture: "amd64", OS: "linux"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store, keyrings: map[string]keyring{"foo": kr}}
+
+	signBinary := func(from, to, binary string) []byte {
+		digest := fmt.Sprintf("%x", sha256.Sum256([]byte(binary)))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, signature.RollbackDigest("foo", from, to, digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	sign := func(from, to string) []byte {
+		return signBinary(from, to, to)
+	}
+	rollback := func(version, from string, sig []byte) RollbackData {
+		return RollbackData{Version: version, From: from, Architecture: "amd64", OS: "linux", Signature: sig}
+	}
+	for _, tc := range []struct {
+		data RollbackData
+		want int
+	}{
+		{rollback("v0.0.3", "v0.0.2", sign("v0.0.2", "v0.0.3")), 422},
+		{rollback("v0.0.2", "v0.0.3", nil), 422},
+		{rollback("v0.0.2", "v0.0.3", sign("v0.0.3", "v0.0.1")), 422},
+		{rollback("v0.0.0", "v0.0.3", sign("v0.0.3", "v0.0.0")), 404},
+		{RollbackData{Version: "v0.0.2", From: "v0.0.3", Architecture: "arm", OS: "linux", Signature: sign("v0.0.3", "v0.0.2")}, 422},
+		{rollback("v0.0.2", "v0.0.3", signBinary("v0.0.3", "v0.0.2", "other binary")), 422},
+		{rollback("v0.0.2", "v0.0.3", sign("v0.0.3", "v0.0.2")), 200},
+	} {
+		body, _ := json.Marshal(tc.data)
+		ctx, w := newTestRequestContext("PUT", "/rollback/foo", string(body))
+		svc.RollbackHandler(ctx)
+		if w.Code != tc.want {
+			t.Errorf("Wrong status code %d for rollback to %s, want %d: %s", w.Code, tc.data.Version, tc.want, w.Body.String())
+		}
+	}
+
+	for _, tc := range []struct {
+		version, want string
+	}{
+		{"v0.0.1", "v0.0.2"},
+		{"v0.0.2", "v0.0.2"},
+		{"v0.0.3", "v0.0.2"},
+	} {
+		latest, err := newTestUpdate(tc.version).GetLatestVersion(store)
+		if err != nil || latest != tc.want {
+			t.Errorf("Wrong latest version for %s: %s %v, want %s", tc.version, latest, err, tc.want)
+		}
+	}
+
+	release, err := readRelease(store, newTestUpdate("v0.0.2"))
+	if err != nil || release.Rollback == nil || release.Rollback.KeyID != "release" {
+		t.Fatalf("Wrong rollback %+v: %v", release, err)
+	}
+
+	upload := &UploadData{Data: []byte("v0.0.4"), Version: "v0.0.4", Architecture: "amd64", OS: "linux"}
+	if err := upload.Save(store, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := newTestUpdate("v0.0.3").GetLatestVersion(store)
+	if err != nil || latest != "v0.0.4" {
+		t.Fatalf("Rolled back client not updated to fixed release: %s %v", latest, err)
+	}
+}
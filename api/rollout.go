@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// inRollout decides deterministically, if the client with installID
+// is one of the given percentage of clients, which are offered the
+// release u. Clients are bucketed by a hash of the install ID and the
+// release, such that every release is rolled out to a different
+// subset of clients. Clients without install ID are only offered
+// releases rolled out to 100%.
+func inRollout(u *Update, installID string, percentage int) bool {
+	if percentage >= 100 {
+		return true
+	}
+	if percentage <= 0 || installID == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(u.Name + "/" + u.Version + "/" + installID))
+	bucket := binary.BigEndian.Uint32(h[:4]) % 100
+	return int(bucket) < percentage
+}
+
+// forEachPlatform calls fn for every supported system, which has an
+// artifact of the application name in version. It returns the number
+// of found artifacts.
+func forEachPlatform(store Storage, name, version string, fn func(u *Update) error) (int, error) {
+	n := 0
+	for system := range supported {
+		versions, err := store.Versions(name, system)
+		if err != nil {
+			return n, err
+		}
+		for _, v := range versions {
+			if v != version {
+				continue
+			}
+			n++
+			if err := fn(&Update{Name: name, Version: version, System: system}); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// updateReleases applies fn to the release metadata of all artifacts
+// of application name in version and stores the result.
+func updateReleases(store Storage, name, version string, fn func(r *Release)) (int, error) {
+	return forEachPlatform(store, name, version, func(u *Update) error {
+		release, err := readRelease(store, u)
+		if err != nil {
+			return err
+		}
+		fn(release)
+		return store.PutSidecar(u, sidecarRelease, release.marshal())
+	})
+}
+
+// RolloutData changes the rollout percentage of a release.
+type RolloutData struct {
+	Version    string `json:"version"`    // version of the release
+	Percentage int    `json:"percentage"` // percentage of clients, which are offered the release
+}
+
+// RolloutHandler handles /rollout/:name endpoint
+func (svc *Service) RolloutHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+
+	var rollout RolloutData
+	if err := ginCtx.BindJSON(&rollout); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)})
+		return
+	}
+	if rollout.Percentage < 0 || rollout.Percentage > 100 {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Invalid percentage %d, has to be between 0 and 100", rollout.Percentage)})
+		return
+	}
+
+	n, err := updateReleases(svc.Storage, name, rollout.Version, func(r *Release) {
+		r.Rollout = rollout.Percentage
+	})
+	if err != nil {
+		glog.Errorf("Failed to change rollout of %s %s: %v", name, rollout.Version, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to change rollout of application '%s' version %s", name, rollout.Version)})
+		return
+	}
+	if n == 0 {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' version %s not found", name, rollout.Version)})
+		return
+	}
+	glog.Infof("Changed rollout of %s %s to %d%%", name, rollout.Version, rollout.Percentage)
+	ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("rolled out application '%s' version %s to %d%% of clients", name, rollout.Version, rollout.Percentage)})
+}
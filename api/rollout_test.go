@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInRollout(t *testing.T) {
+	u := newTestUpdate("v1.0.0")
+	if inRollout(u, "", 99) {
+		t.Fatal("Client without install ID is part of a staged rollout")
+	}
+	if !inRollout(u, "", 100) {
+		t.Fatal("Client without install ID is not part of a full rollout")
+	}
+
+	n := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("install-%d", i)
+		in := inRollout(u, id, 25)
+		if in != inRollout(u, id, 25) {
+			t.Fatalf("Rollout decision for %s is not deterministic", id)
+		}
+		if in {
+			n++
+			if !inRollout(u, id, 50) {
+				t.Fatalf("Client %s dropped out of a growing rollout", id)
+			}
+		}
+	}
+	if n < 200 || n > 300 {
+		t.Fatalf("%d of 1000 clients are part of a 25%% rollout", n)
+	}
+}
+
+func TestRolloutHandler(t *testing.T) {
+	store := newMemStorage()
+	zero := 0
+	for _, upload := range []*UploadData{
+		{Data: []byte("v1.0.0"), Version: "v1.0.0", Architecture: "amd64", OS: "linux"},
+		{Data: []byte("v1.1.0"), Version: "v1.1.0", Architecture: "amd64", OS: "linux", Rollout: &zero},
+	} {
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	u := newTestUpdate("v0.0.1")
+	u.InstallID = "install-1"
+	latest, err := u.GetLatestVersion(store)
+	if err != nil || latest != "v1.0.0" {
+		t.Fatalf("Wrong latest version %s during 0%% rollout: %v", latest, err)
+	}
+
+	ctx, w := newTestRequestContext("PUT", "/rollout/foo", `{"version": "v1.1.0", "percentage": 100}`)
+	svc.RolloutHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+	latest, err = u.GetLatestVersion(store)
+	if err != nil || latest != "v1.1.0" {
+		t.Fatalf("Wrong latest version %s during 100%% rollout: %v", latest, err)
+	}
+
+	ctx, w = newTestRequestContext("PUT", "/rollout/foo", `{"version": "v2.0.0", "percentage": 100}`)
+	svc.RolloutHandler(ctx)
+	if w.Code != 404 {
+		t.Fatalf("Wrong status code %d for unknown version", w.Code)
+	}
+
+	ctx, w = newTestRequestContext("PUT", "/rollout/foo", `{"version": "v1.1.0", "percentage": 101}`)
+	svc.RolloutHandler(ctx)
+	if w.Code != 422 {
+		t.Fatalf("Wrong status code %d for invalid percentage", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/tls"
 	"fmt"
@@ -36,10 +37,27 @@ type ServiceConfig struct {
 
 var cfg *conf.Config
 
+// defaultStorageDir is used as base directory of the FileStorage if
+// not configured otherwise.
+const defaultStorageDir = "/tmp/bindata"
+
 // Service is the main struct
 type Service struct {
 	Healthy bool
+	// Storage is used to read and write all artifacts. If nil, Run
+	// uses a FileStorage in the configured storage directory.
+	Storage Storage
 	sig     chan os.Signal
+	// patches deduplicates concurrent creation of the same patch
+	patches flightGroup
+	// keyrings contain the keys trusted to sign uploads by
+	// application name
+	keyrings map[string]keyring
+	// manifestKey signs release manifests, nil if not configured
+	manifestKey crypto.Signer
+	// reports aggregates the versions and update outcomes reported
+	// by clients
+	reports reportStore
 }
 
 func NewService() *Service {
@@ -61,6 +79,24 @@ func (svc *Service) RegisterShutdown() {
 	}()
 }
 
+// newStorage returns the Storage configured by storage_backend,
+// which is one of "file" (default) or "s3".
+func newStorage(config *conf.Config) (Storage, error) {
+	switch config.StorageBackend {
+	case "", "file":
+		dir := config.StorageDir
+		if dir == "" {
+			dir = defaultStorageDir
+		}
+		glog.Infof("Use file storage in %s", dir)
+		return NewFileStorage(dir), nil
+	case "s3":
+		glog.Infof("Use s3 storage %s/%s", config.S3Endpoint, config.S3Bucket)
+		return NewS3StorageFromEnv(config.S3Endpoint, config.S3Region, config.S3Bucket, config.S3Prefix)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+}
+
 func (svc *Service) checkDependencies() bool {
 	// TODO: you may want to check if you can connect to your dependencies here
 	return true
@@ -71,6 +107,25 @@ func (svc *Service) checkDependencies() bool {
 func (svc *Service) Run(config *ServiceConfig) error {
 	cfg = config.Config
 
+	if svc.Storage == nil {
+		store, err := newStorage(cfg)
+		if err != nil {
+			return err
+		}
+		svc.Storage = newCachedStorage(store, releaseCacheTTL())
+	}
+	if _, err := svc.checkReleases(); err != nil {
+		glog.Errorf("Failed to check releases: %v", err)
+	}
+	keyrings, err := loadKeyrings(cfg)
+	if err != nil {
+		return err
+	}
+	svc.keyrings = keyrings
+	if svc.manifestKey, err = loadManifestKey(cfg); err != nil {
+		return err
+	}
+
 	// init gin
 	if !cfg.DebugEnabled {
 		gin.SetMode(gin.ReleaseMode)
@@ -79,7 +134,8 @@ func (svc *Service) Run(config *ServiceConfig) error {
 	// initialize CounterAspect and reset every minute
 	counterAspect := ginmon.NewCounterAspect()
 	counterAspect.StartTimer(1 * time.Minute)
-	asps := []aspects.Aspect{counterAspect}
+	// the reports aspect shows rollouts halted by failure reports
+	asps := []aspects.Aspect{counterAspect, &svc.reports}
 	gomonitor.Start(cfg.MonitorPort, asps)
 
 	// Middleware
@@ -114,7 +170,17 @@ func (svc *Service) Run(config *ServiceConfig) error {
 		private.GET("/patch-update/:name", svc.PatchUpdateHandler)
 		private.GET("/signed-update/:name", svc.SignedUpdateHandler)
 		private.GET("/signed-patch-update/:name", svc.SignedPatchUpdateHandler)
+		private.GET("/check/:name", svc.CheckHandler)
 		private.PUT("/upload/:name", svc.UploadHandler)
+		private.PUT("/rollout/:name", svc.RolloutHandler)
+		private.PUT("/rollback/:name", svc.RollbackHandler)
+		private.PUT("/yank/:name", svc.YankHandler)
+		private.GET("/manifest/:name", svc.ManifestHandler)
+		private.GET("/apps", svc.AppsHandler)
+		private.GET("/apps/:name/versions", svc.VersionsHandler)
+		private.GET("/apps/:name/versions/:version", svc.VersionHandler)
+		private.POST("/report/:name", svc.ReportHandler)
+		private.GET("/report/:name", svc.ReportsHandler)
 	} else {
 		// public routes
 		router.GET("/", svc.RootHandler)
@@ -122,7 +188,17 @@ func (svc *Service) Run(config *ServiceConfig) error {
 		router.GET("/patch-update/:name", svc.PatchUpdateHandler)
 		router.GET("/signed-update/:name", svc.SignedUpdateHandler)
 		router.GET("/signed-patch-update/:name", svc.SignedPatchUpdateHandler)
+		router.GET("/check/:name", svc.CheckHandler)
 		router.PUT("/upload/:name", svc.UploadHandler)
+		router.PUT("/rollout/:name", svc.RolloutHandler)
+		router.PUT("/rollback/:name", svc.RollbackHandler)
+		router.PUT("/yank/:name", svc.YankHandler)
+		router.GET("/manifest/:name", svc.ManifestHandler)
+		router.GET("/apps", svc.AppsHandler)
+		router.GET("/apps/:name/versions", svc.VersionsHandler)
+		router.GET("/apps/:name/versions/:version", svc.VersionHandler)
+		router.POST("/report/:name", svc.ReportHandler)
+		router.GET("/report/:name", svc.ReportsHandler)
 	}
 
 	// TLS config
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// Release states stored in the release metadata. Active releases have
+// an empty state.
+const (
+	// stateYanked releases are never offered as update, but can still
+	// be patched from.
+	stateYanked = "yanked"
+	// stateDeprecated releases are offered, but clients running them
+	// get a Warning header.
+	stateDeprecated = "deprecated"
+	// statePaused releases are not offered as update, but clients
+	// running them are not warned. Rollouts are paused automatically,
+	// if too many clients report failed updates.
+	statePaused = "paused"
+	stateActive = "active"
+)
+
+// StateData changes the state of a release.
+type StateData struct {
+	Version string `json:"version"` // version of the release
+	State   string `json:"state"`   // yanked, deprecated, paused or active
+}
+
+// warnState sets a Warning header, if the release the client of u is
+// running is yanked or deprecated.
+func (svc *Service) warnState(ginCtx *gin.Context, u *Update) {
+	release, err := readRelease(svc.Storage, u)
+	if err != nil {
+		glog.Errorf("Failed to read release of %s: %v", u, err)
+		return
+	}
+	if release.State == stateYanked || release.State == stateDeprecated {
+		ginCtx.Header("Warning", fmt.Sprintf(`299 binary-patch "%s %s is %s"`, u.Name, u.Version, release.State))
+	}
+}
+
+// YankHandler handles /yank/:name endpoint, which changes the state
+// of a release to yanked, deprecated, paused or back to active.
+func (svc *Service) YankHandler(ginCtx *gin.Context) {
+	name := ginCtx.Param("name")
+
+	var data StateData
+	if err := ginCtx.BindJSON(&data); err != nil {
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to unmarshal json of application '%s': %v", name, err)})
+		return
+	}
+	if data.State == "" {
+		data.State = stateYanked
+	}
+	state := data.State
+	switch state {
+	case stateYanked, stateDeprecated, statePaused:
+	case stateActive:
+		state = ""
+	default:
+		ginCtx.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Invalid state %q, has to be one of %s, %s, %s or %s", data.State, stateYanked, stateDeprecated, statePaused, stateActive)})
+		return
+	}
+
+	n, err := updateReleases(svc.Storage, name, data.Version, func(r *Release) {
+		r.State = state
+	})
+	if err != nil {
+		glog.Errorf("Failed to change state of %s %s: %v", name, data.Version, err)
+		ginCtx.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to change state of application '%s' version %s", name, data.Version)})
+		return
+	}
+	if n == 0 {
+		ginCtx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Application '%s' version %s not found", name, data.Version)})
+		return
+	}
+	glog.Infof("Changed state of %s %s to %s", name, data.Version, data.State)
+	ginCtx.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("marked application '%s' version %s as %s", name, data.Version, data.State)})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYankHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.1", "v0.0.2", "v0.0.3"} {
+		upload := &UploadData{Data: []byte("binary " + v), Version: v, Architecture: "amd64", OS: "linux"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"version": "v0.0.3", "state": "removed"}`, 422},
+		{`{"version": "v0.0.4"}`, 404},
+		{`{"version": "v0.0.3"}`, 200},
+		{`{"version": "v0.0.1", "state": "deprecated"}`, 200},
+	} {
+		ctx, w := newTestRequestContext("PUT", "/yank/foo", tc.body)
+		svc.YankHandler(ctx)
+		if w.Code != tc.want {
+			t.Errorf("Wrong status code %d for %s, want %d: %s", w.Code, tc.body, tc.want, w.Body.String())
+		}
+	}
+
+	latest, err := newTestUpdate("v0.0.1").GetLatestVersion(store)
+	if err != nil || latest != "v0.0.2" {
+		t.Fatalf("Wrong latest version %s: %v", latest, err)
+	}
+
+	ctx, w := newTestContext("/update/foo?version=v0.0.1&arch=amd64&os=linux")
+	svc.UpdateHandler(ctx)
+	if w.Code != 200 || !strings.Contains(w.Header().Get("Warning"), "deprecated") {
+		t.Fatalf("Missing warning for deprecated release %d: %v", w.Code, w.Header())
+	}
+
+	// yanked releases can still be patched from
+	ctx, w = newTestContext("/patch-update/foo?version=v0.0.3&arch=amd64&os=linux")
+	svc.PatchUpdateHandler(ctx)
+	if ctx.Writer.Status() != 304 || !strings.Contains(w.Header().Get("Warning"), "yanked") {
+		t.Fatalf("Wrong response for yanked release %d: %v", ctx.Writer.Status(), w.Header())
+	}
+	if err := (&UploadData{Data: []byte("binary v0.0.4"), Version: "v0.0.4", Architecture: "amd64", OS: "linux"}).Save(store, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, w = newTestContext("/patch-update/foo?version=v0.0.3&arch=amd64&os=linux")
+	svc.PatchUpdateHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Failed to patch from yanked release %d: %s", w.Code, w.Body.String())
+	}
+
+	ctx, w = newTestRequestContext("PUT", "/yank/foo", `{"version": "v0.0.3", "state": "active"}`)
+	svc.YankHandler(ctx)
+	if release, err := readRelease(store, newTestUpdate("v0.0.3")); w.Code != 200 || err != nil || release.State != "" {
+		t.Fatalf("Failed to activate release %d: %+v %v", w.Code, release, err)
+	}
+}
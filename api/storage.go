@@ -0,0 +1,641 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/szuecs/binary-patch/semver"
+)
+
+const (
+	sidecarSHA256    = "sha256"
+	sidecarSignature = "signature"
+)
+
+// staleUploadAge is the age of temporary files, after which they are
+// considered to be left by an interrupted upload.
+const staleUploadAge = time.Hour
+
+const (
+	// lockRefresh is the interval, in which the lock file of an upload
+	// in progress is touched.
+	lockRefresh = 15 * time.Second
+	// staleLockAge is the age of a lock file, after which it is
+	// considered to be left by an interrupted upload.
+	staleLockAge = 4 * lockRefresh
+)
+
+var (
+	errArtifactExists = errors.New("Artifact already exists: ")
+	errInvalidKey     = errors.New("Invalid artifact key: ")
+)
+
+// Storage abstracts the place where release artifacts and their
+// sidecars (p.e. sha256 and signature files) are stored. All handlers
+// access artifacts only through a Storage.
+type Storage interface {
+	// Apps returns the names of all applications, which have at
+	// least one artifact.
+	Apps() ([]string, error)
+	// Versions returns the versions of all artifacts stored for
+	// application name and the given system.
+	Versions(name string, system ArchAndOS) ([]string, error)
+	// Open returns the artifact of u. Caller has to close the
+	// io.ReadCloser.
+	Open(u *Update) (io.ReadCloser, error)
+	// Stat returns size and modification time of the artifact of u.
+	Stat(u *Update) (*ArtifactInfo, error)
+	// OpenSidecar returns the sidecar with extension ext of the
+	// artifact of u. Caller has to close the io.ReadCloser.
+	OpenSidecar(u *Update, ext string) (io.ReadCloser, error)
+	// Put stores data as artifact of u together with the given
+	// sidecars, which are mapped by extension. The artifact must
+	// not be visible to Versions or Open before all data was
+	// written. Put fails if the artifact already exists or if the
+	// data does not match the sha256 sidecar.
+	Put(u *Update, data io.Reader, sidecars map[string][]byte) error
+	// PutSidecar creates or replaces the sidecar with extension ext
+	// of the artifact of u.
+	PutSidecar(u *Update, ext string, data []byte) error
+	// Delete removes the artifact of u and all its sidecars.
+	Delete(u *Update) error
+}
+
+// ArtifactInfo describes a stored artifact.
+type ArtifactInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// parseArtifactName returns the application, version and system of
+// the artifact stored as name, p.e. foo_v0.0.1_amd64linux. Sidecars
+// and other files are not parsed.
+func parseArtifactName(name string) (*Update, bool) {
+	for system := range supported {
+		suffix := "_" + system.Arch + system.OS
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		rest := strings.TrimSuffix(name, suffix)
+		i := strings.LastIndex(rest, "_")
+		if i <= 0 || i == len(rest)-1 {
+			return nil, false
+		}
+		return &Update{Name: rest[:i], Version: rest[i+1:], System: system}, true
+	}
+	return nil, false
+}
+
+// appNames returns the sorted and unique application names of the
+// artifacts stored as names.
+func appNames(names []string) []string {
+	seen := make(map[string]bool)
+	apps := []string{}
+	for _, name := range names {
+		u, ok := parseArtifactName(name)
+		if !ok || seen[u.Name] {
+			continue
+		}
+		seen[u.Name] = true
+		apps = append(apps, u.Name)
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// readSidecar returns the content of the sidecar with extension ext
+// of the artifact of u.
+func readSidecar(store Storage, u *Update, ext string) ([]byte, error) {
+	rc, err := store.OpenSidecar(u, ext)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// checkDigest returns an error, if sidecars contain a sha256 sidecar,
+// which does not match the SHA256 digest of the data of u.
+func checkDigest(u *Update, sidecars map[string][]byte, digest []byte) error {
+	expected, ok := sidecars[sidecarSHA256]
+	if !ok {
+		return nil
+	}
+	if actual := fmt.Sprintf("%x", digest); actual != strings.TrimSpace(string(expected)) {
+		return fmt.Errorf("sha256 of %s is %s, expected %s", u, actual, bytes.TrimSpace(expected))
+	}
+	return nil
+}
+
+// rangeOpener is implemented by storages, which can not seek, but
+// read an artifact from an offset, p.e. with a range request.
+type rangeOpener interface {
+	// OpenRange returns the artifact of u from offset to the end.
+	// Caller has to close the io.ReadCloser.
+	OpenRange(u *Update, offset int64) (io.ReadCloser, error)
+}
+
+// rangeReader is an io.ReadSeeker of the artifact of u stored in a
+// rangeOpener. The artifact is opened at the current offset by the
+// first Read after a Seek, such that ranges are streamed without
+// buffering the artifact.
+type rangeReader struct {
+	store  rangeOpener
+	u      *Update
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	if rr.offset >= rr.size {
+		return 0, io.EOF
+	}
+	if rr.rc == nil {
+		rc, err := rr.store.OpenRange(rr.u, rr.offset)
+		if err != nil {
+			return 0, err
+		}
+		rr.rc = rc
+	}
+	n, err := rr.rc.Read(p)
+	rr.offset += int64(n)
+	return n, err
+}
+
+func (rr *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rr.offset
+	case io.SeekEnd:
+		offset += rr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != rr.offset {
+		rr.Close()
+		rr.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes the artifact opened by Read.
+func (rr *rangeReader) Close() error {
+	if rr.rc == nil {
+		return nil
+	}
+	err := rr.rc.Close()
+	rr.rc = nil
+	return err
+}
+
+// recoverer is implemented by storages, which can clean up data left
+// by interrupted uploads.
+type recoverer interface {
+	// Recover removes data of incomplete uploads and returns the
+	// names of the removed files or objects.
+	Recover() ([]string, error)
+}
+
+// orphanSidecars returns the sidecars in names, whose artifact is
+// not in names. They are left, if an upload was interrupted before
+// the artifact was published.
+func orphanSidecars(names []string) []string {
+	artifacts := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := parseArtifactName(name); ok {
+			artifacts[name] = true
+		}
+	}
+	var orphans []string
+	for _, name := range names {
+		if artifacts[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		// the system suffix of the artifact name contains no dot
+		i := strings.LastIndex(name, "_")
+		j := strings.Index(name[i+1:], ".")
+		if i < 0 || j < 0 {
+			continue
+		}
+		if artifact := name[:i+1+j]; !artifacts[artifact] {
+			if _, ok := parseArtifactName(artifact); ok {
+				orphans = append(orphans, name)
+			}
+		}
+	}
+	return orphans
+}
+
+// checkReleases removes data of interrupted uploads, if the storage
+// supports it, and reports published releases without sha256 sidecar,
+// which can not be verified by clients, and artifacts with invalid
+// versions, which are not offered to clients. It returns the
+// incomplete releases.
+func (svc *Service) checkReleases() ([]*Update, error) {
+	if r, ok := backend(svc.Storage).(recoverer); ok {
+		removed, err := r.Recover()
+		for _, name := range removed {
+			glog.Warningf("Removed %s of an interrupted upload", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	apps, err := svc.Storage.Apps()
+	if err != nil {
+		return nil, err
+	}
+	var incomplete []*Update
+	for _, name := range apps {
+		for system := range supported {
+			versions, err := svc.Storage.Versions(name, system)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range versions {
+				u := &Update{Name: name, Version: v, System: system}
+				if _, err := semver.Parse(v); err != nil {
+					glog.Errorf("Artifact %s has an invalid version and is not offered to clients: %v", u, err)
+				}
+				if _, err := readSidecar(svc.Storage, u, sidecarSHA256); err != nil {
+					glog.Errorf("Incomplete release %s: %v", u, err)
+					incomplete = append(incomplete, u)
+				}
+			}
+		}
+	}
+	return incomplete, nil
+}
+
+// FileStorage stores artifacts in a local directory. An artifact is
+// stored as <dir>/<name>_<version>_<arch><os> and its sidecars next
+// to it with the sidecar extension appended,
+// p.e. <dir>/<name>_<version>_<arch><os>.sha256.
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage returns a FileStorage using dir as base directory.
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{dir: dir}
+}
+
+// path returns the path of the artifact of u. Keys with a path
+// separator or .. are refused, such that no file outside of the
+// directory can be accessed.
+func (fs *FileStorage) path(u *Update) (string, error) {
+	key := u.String()
+	if strings.ContainsAny(key, "/"+string(os.PathSeparator)) || strings.Contains(key, "..") {
+		return "", errors.Wrap(errInvalidKey, key)
+	}
+	return filepath.Join(fs.dir, key), nil
+}
+
+// Apps implements Storage.
+func (fs *FileStorage) Apps() ([]string, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %v", fs.dir, err)
+	}
+	var names []string
+	for _, fi := range files {
+		// skip temporary files
+		if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+			names = append(names, fi.Name())
+		}
+	}
+	return appNames(names), nil
+}
+
+// Versions implements Storage.
+func (fs *FileStorage) Versions(name string, system ArchAndOS) ([]string, error) {
+	prefix := name + "_"
+	suffix := "_" + system.Arch + system.OS
+	files, err := filepath.Glob(filepath.Join(fs.dir, prefix+"*"+suffix))
+	if err != nil {
+		return nil, fmt.Errorf("could not glob files of %s: %v", name, err)
+	}
+	glog.V(2).Infof("found %d files by glob", len(files))
+
+	versions := make([]string, 0, len(files))
+	for _, fpath := range files {
+		fname := filepath.Base(fpath)
+		v := strings.TrimSuffix(strings.TrimPrefix(fname, prefix), suffix)
+		// skip artifacts of other applications whose names start with name_
+		if strings.Contains(v, "_") {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// Open implements Storage.
+func (fs *FileStorage) Open(u *Update) (io.ReadCloser, error) {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(fpath)
+	if err != nil {
+		return nil, errors.Wrap(errBinaryNotFound, u.String())
+	}
+	return fd, nil
+}
+
+// Stat implements Storage.
+func (fs *FileStorage) Stat(u *Update) (*ArtifactInfo, error) {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil, errors.Wrap(errBinaryNotFound, u.String())
+	}
+	return &ArtifactInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// OpenSidecar implements Storage.
+func (fs *FileStorage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(fpath + "." + ext)
+	if err != nil {
+		return nil, errors.Wrap(errBinaryNotFound, u.String()+"."+ext)
+	}
+	return fd, nil
+}
+
+// Put implements Storage. All files are written to temporary files
+// and synced first. Only if all of them were written and the data
+// matches the sha256 sidecar, they are moved into place, the
+// artifact itself as the last one, which publishes the release.
+// Concurrent uploads of the same artifact are excluded by a lock
+// file, such that sidecars of one upload can not be published with
+// the artifact of another one.
+func (fs *FileStorage) Put(u *Update, data io.Reader, sidecars map[string][]byte) error {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return err
+	}
+	unlock, err := fs.lock(u)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = os.Stat(fpath)
+	if err == nil {
+		return errors.Wrap(errArtifactExists, u.String())
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %v", fpath, err)
+	}
+
+	staged := make(map[string]string)
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	h := sha256.New()
+	tmp, err := fs.writeTemp(fpath, io.TeeReader(data, h))
+	if err != nil {
+		return err
+	}
+	staged[fpath] = tmp
+	if err = checkDigest(u, sidecars, h.Sum(nil)); err != nil {
+		return err
+	}
+	for ext, b := range sidecars {
+		tmp, err := fs.writeTemp(fpath+"."+ext, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		staged[fpath+"."+ext] = tmp
+	}
+
+	for ext := range sidecars {
+		if err = fs.rename(staged[fpath+"."+ext], fpath+"."+ext); err != nil {
+			return err
+		}
+		delete(staged, fpath+"."+ext)
+	}
+	// a link fails instead of replacing an existing artifact
+	if err = os.Link(staged[fpath], fpath); err != nil {
+		if os.IsExist(err) {
+			return errors.Wrap(errArtifactExists, u.String())
+		}
+		return fmt.Errorf("failed to link %s: %v", fpath, err)
+	}
+	return fs.syncDir()
+}
+
+// lock creates the lock file of the artifact of u and returns a
+// function to remove it. The lock file is touched every lockRefresh
+// while the upload is in progress, such that a lock file left by an
+// interrupted upload is detected by its age and broken.
+func (fs *FileStorage) lock(u *Update) (func(), error) {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return nil, err
+	}
+	lpath := filepath.Join(fs.dir, "."+filepath.Base(fpath)+".lock")
+	fd, err := os.OpenFile(lpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) && fs.breakStaleLock(lpath) {
+		fd, err = os.OpenFile(lpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	}
+	if os.IsExist(err) {
+		return nil, errors.Wrapf(errArtifactExists, "%s is being uploaded", u)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %v", u, err)
+	}
+	fd.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := os.Chtimes(lpath, now, now); err != nil {
+					glog.Warningf("Failed to refresh lock of %s: %v", u, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		os.Remove(lpath)
+	}, nil
+}
+
+// breakStaleLock removes the lock file lpath, if it was not touched
+// for staleLockAge, and reports whether the lock can be taken again.
+// The lock file is renamed before it is removed, such that only one
+// upload breaks it.
+func (fs *FileStorage) breakStaleLock(lpath string) bool {
+	fi, err := os.Stat(lpath)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	if time.Since(fi.ModTime()) < staleLockAge {
+		return false
+	}
+	broken := fmt.Sprintf("%s.%d.%d", lpath, os.Getpid(), time.Now().UnixNano())
+	if err = os.Rename(lpath, broken); err != nil {
+		return os.IsNotExist(err)
+	}
+	defer os.Remove(broken)
+	if fi, err = os.Stat(broken); err == nil && time.Since(fi.ModTime()) < staleLockAge {
+		// another upload broke the stale lock and took it in between
+		os.Link(broken, lpath)
+		return false
+	}
+	glog.Warningf("Removed stale lock %s of an interrupted upload", filepath.Base(lpath))
+	return true
+}
+
+// PutSidecar implements Storage.
+func (fs *FileStorage) PutSidecar(u *Update, ext string, data []byte) error {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return err
+	}
+	fpath += "." + ext
+	tmp, err := fs.writeTemp(fpath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err = fs.rename(tmp, fpath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return fs.syncDir()
+}
+
+// writeTemp writes r to a synced temporary file in the directory of
+// fpath and returns its path. Temporary files start with a dot, such
+// that they are not listed as artifacts.
+func (fs *FileStorage) writeTemp(fpath string, r io.Reader) (string, error) {
+	fd, err := ioutil.TempFile(fs.dir, "."+filepath.Base(fpath)+".")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %v", fpath, err)
+	}
+	tmp := fd.Name()
+	_, err = io.Copy(fd, r)
+	if err == nil {
+		err = fd.Chmod(0440)
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %v", fpath, err)
+	}
+	return tmp, nil
+}
+
+func (fs *FileStorage) rename(tmp, fpath string) error {
+	if err := os.Rename(tmp, fpath); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", fpath, err)
+	}
+	return nil
+}
+
+// syncDir syncs the directory, such that renamed files survive a
+// crash.
+func (fs *FileStorage) syncDir() error {
+	dir, err := os.Open(fs.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", fs.dir, err)
+	}
+	defer dir.Close()
+	if err = dir.Sync(); err != nil && !os.IsPermission(err) {
+		glog.Warningf("Failed to sync %s: %v", fs.dir, err)
+	}
+	return nil
+}
+
+// Recover implements recoverer. It removes temporary files and
+// sidecars without artifact older than staleUploadAge, which were left
+// by interrupted uploads. Younger files may belong to an upload in
+// progress on another replica.
+func (fs *FileStorage) Recover() ([]string, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %v", fs.dir, err)
+	}
+	var names []string
+	for _, fi := range files {
+		if fi.Mode().IsRegular() {
+			names = append(names, fi.Name())
+		}
+	}
+
+	var candidates, removed []string
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, name := range append(candidates, orphanSidecars(names)...) {
+		if fi, err := os.Stat(filepath.Join(fs.dir, name)); err != nil || time.Since(fi.ModTime()) < staleUploadAge {
+			continue
+		}
+		removed = append(removed, name)
+	}
+	for _, name := range removed {
+		if err := os.Remove(filepath.Join(fs.dir, name)); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %v", name, err)
+		}
+	}
+	return removed, nil
+}
+
+// Delete implements Storage. The artifact is removed first, such that
+// it is not visible anymore if removing a sidecar fails.
+func (fs *FileStorage) Delete(u *Update) error {
+	fpath, err := fs.path(u)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %v", fpath, err)
+	}
+	sidecars, err := filepath.Glob(fpath + ".*")
+	if err != nil {
+		return fmt.Errorf("could not glob sidecars of %s: %v", fpath, err)
+	}
+	for _, sidecar := range sidecars {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %v", sidecar, err)
+		}
+	}
+	return nil
+}
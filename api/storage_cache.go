@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultReleaseCacheTTL is the time release metadata is cached,
+	// if not configured otherwise.
+	defaultReleaseCacheTTL = 10 * time.Second
+	// maxCachedEntries limits the entries of a cachedStorage, such
+	// that requests for unknown applications and versions can not
+	// grow the cache without bound.
+	maxCachedEntries = 10000
+)
+
+// cachedStorage caches the version listings and release sidecars of
+// a Storage, which are read by every update and check request. Put,
+// PutSidecar and Delete invalidate the cached data of the artifact.
+// Entries expire after ttl, such that changes by other replicas are
+// seen, too.
+type cachedStorage struct {
+	Storage
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+	// generation is incremented by every invalidation, such that
+	// data read before is not cached
+	generation int
+	versions   map[string]cachedVersions
+	sidecars   map[string]cachedSidecar
+}
+
+type cachedVersions struct {
+	versions []string
+	expires  time.Time
+}
+
+type cachedSidecar struct {
+	data    []byte
+	missing bool
+	expires time.Time
+}
+
+// newCachedStorage returns store with cached release metadata.
+func newCachedStorage(store Storage, ttl time.Duration) *cachedStorage {
+	return &cachedStorage{
+		Storage:  store,
+		ttl:      ttl,
+		now:      time.Now,
+		versions: make(map[string]cachedVersions),
+		sidecars: make(map[string]cachedSidecar),
+	}
+}
+
+// releaseCacheTTL returns the configured time release metadata is
+// cached.
+func releaseCacheTTL() time.Duration {
+	if cfg == nil || cfg.ReleaseCacheTTL <= 0 {
+		return defaultReleaseCacheTTL
+	}
+	return cfg.ReleaseCacheTTL
+}
+
+// backend returns the storage cached by store or store itself, such
+// that optional interfaces of the storage can be checked.
+func backend(store Storage) Storage {
+	if cs, ok := store.(*cachedStorage); ok {
+		return cs.Storage
+	}
+	return store
+}
+
+func versionsKey(name string, system ArchAndOS) string {
+	return name + "_" + system.Arch + system.OS
+}
+
+// Versions implements Storage.
+func (cs *cachedStorage) Versions(name string, system ArchAndOS) ([]string, error) {
+	key := versionsKey(name, system)
+	cs.mu.Lock()
+	e, ok := cs.versions[key]
+	generation := cs.generation
+	cs.mu.Unlock()
+	if ok && cs.now().Before(e.expires) {
+		return append([]string(nil), e.versions...), nil
+	}
+
+	versions, err := cs.Storage.Versions(name, system)
+	if err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if generation == cs.generation {
+		if len(cs.versions) >= maxCachedEntries {
+			cs.versions = make(map[string]cachedVersions)
+		}
+		cs.versions[key] = cachedVersions{versions: append([]string(nil), versions...), expires: cs.now().Add(cs.ttl)}
+	}
+	return versions, nil
+}
+
+// OpenSidecar implements Storage. Only release sidecars are cached.
+func (cs *cachedStorage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	if ext != sidecarRelease {
+		return cs.Storage.OpenSidecar(u, ext)
+	}
+	key := u.String() + "." + ext
+	cs.mu.Lock()
+	e, ok := cs.sidecars[key]
+	generation := cs.generation
+	cs.mu.Unlock()
+	if !ok || !cs.now().Before(e.expires) {
+		var err error
+		if e, err = cs.readSidecar(u, ext); err != nil {
+			return nil, err
+		}
+		cs.mu.Lock()
+		if generation == cs.generation {
+			if len(cs.sidecars) >= maxCachedEntries {
+				cs.sidecars = make(map[string]cachedSidecar)
+			}
+			cs.sidecars[key] = e
+		}
+		cs.mu.Unlock()
+	}
+	if e.missing {
+		return nil, errors.Wrap(errBinaryNotFound, key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+func (cs *cachedStorage) readSidecar(u *Update, ext string) (cachedSidecar, error) {
+	e := cachedSidecar{expires: cs.now().Add(cs.ttl)}
+	b, err := readSidecar(cs.Storage, u, ext)
+	if errors.Cause(err) == errBinaryNotFound {
+		e.missing = true
+		return e, nil
+	}
+	if err != nil {
+		return e, err
+	}
+	e.data = b
+	return e, nil
+}
+
+// Put implements Storage.
+func (cs *cachedStorage) Put(u *Update, data io.Reader, sidecars map[string][]byte) error {
+	defer cs.invalidate(u)
+	return cs.Storage.Put(u, data, sidecars)
+}
+
+// PutSidecar implements Storage.
+func (cs *cachedStorage) PutSidecar(u *Update, ext string, data []byte) error {
+	defer cs.invalidate(u)
+	return cs.Storage.PutSidecar(u, ext, data)
+}
+
+// Delete implements Storage.
+func (cs *cachedStorage) Delete(u *Update) error {
+	defer cs.invalidate(u)
+	return cs.Storage.Delete(u)
+}
+
+// invalidate removes the cached data of the artifact of u.
+func (cs *cachedStorage) invalidate(u *Update) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.generation++
+	delete(cs.versions, versionsKey(u.Name, u.System))
+	delete(cs.sidecars, u.String()+"."+sidecarRelease)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingStorage counts the version listings and sidecar reads of
+// a Storage.
+type countingStorage struct {
+	Storage
+	listings int
+	reads    int
+}
+
+func (cs *countingStorage) Versions(name string, system ArchAndOS) ([]string, error) {
+	cs.listings++
+	return cs.Storage.Versions(name, system)
+}
+
+func (cs *countingStorage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	cs.reads++
+	return cs.Storage.OpenSidecar(u, ext)
+}
+
+func TestCachedStorage(t *testing.T) {
+	testStorage(t, newCachedStorage(newMemStorage(), time.Minute))
+
+	counting := &countingStorage{Storage: newMemStorage()}
+	store := newCachedStorage(counting, time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	for _, v := range []string{"v1.0.0", "v1.1.0"} {
+		upload := &UploadData{Data: []byte(v), Version: v, Architecture: "amd64", OS: "linux"}
+		if err := upload.Save(store, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u := newTestUpdate("v1.0.0")
+	for i := 0; i < 3; i++ {
+		if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.1.0" {
+			t.Fatalf("Wrong latest version %s: %v", latest, err)
+		}
+	}
+	if counting.listings != 1 || counting.reads != 2 {
+		t.Fatalf("Release metadata not cached, %d listings, %d reads", counting.listings, counting.reads)
+	}
+
+	// changed releases are not served from the cache
+	if _, err := updateReleases(store, "foo", "v1.1.0", func(r *Release) { r.State = stateYanked }); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.0.0" {
+		t.Fatalf("Yanked release served from cache %s: %v", latest, err)
+	}
+	upload := &UploadData{Data: []byte("v1.2.0"), Version: "v1.2.0", Architecture: "amd64", OS: "linux"}
+	if err := upload.Save(store, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.2.0" {
+		t.Fatalf("Uploaded release not listed %s: %v", latest, err)
+	}
+
+	// changes of other replicas are seen after the ttl
+	listings := counting.listings
+	if err := counting.Storage.Put(newTestUpdate("v1.3.0"), strings.NewReader("v1.3.0"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.2.0" || counting.listings != listings {
+		t.Fatalf("Versions not cached %s: %v", latest, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if latest, err := u.GetLatestVersion(store); err != nil || latest != "v1.3.0" {
+		t.Fatalf("Cached versions did not expire %s: %v", latest, err)
+	}
+}
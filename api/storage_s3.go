@@ -0,0 +1,679 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+// S3Options configures an S3Storage.
+type S3Options struct {
+	// Endpoint is the base URL of the S3 API,
+	// p.e. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+	// Region is used to sign requests, p.e. eu-central-1
+	Region string
+	// Bucket stores all artifacts
+	Bucket string
+	// Prefix is prepended to all object keys, p.e. "releases/"
+	Prefix string
+	// AccessKeyID, SecretAccessKey and SessionToken are the
+	// credentials to sign requests. SessionToken is optional.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Client is used to do the requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3Storage stores artifacts as objects in a bucket of an S3
+// compatible object storage using the same key layout as the
+// FileStorage: <prefix><name>_<version>_<arch><os> and
+// <prefix><name>_<version>_<arch><os>.<ext> for sidecars. Buckets are
+// addressed path-style, which is supported by AWS and MinIO.
+type S3Storage struct {
+	opts S3Options
+	now  func() time.Time
+}
+
+// NewS3Storage returns a S3Storage configured by opts.
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage requires endpoint and bucket")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	return &S3Storage{opts: opts, now: time.Now}, nil
+}
+
+// NewS3StorageFromEnv returns a S3Storage with credentials read from
+// the environment variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN.
+func NewS3StorageFromEnv(endpoint, region, bucket, prefix string) (*S3Storage, error) {
+	return NewS3Storage(S3Options{
+		Endpoint:        endpoint,
+		Region:          region,
+		Bucket:          bucket,
+		Prefix:          prefix,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+func (s3 *S3Storage) key(u *Update) string {
+	return s3.opts.Prefix + u.String()
+}
+
+// Apps implements Storage.
+func (s3 *S3Storage) Apps() ([]string, error) {
+	keys, err := s3.list(s3.opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects: %v", err)
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, s3.opts.Prefix)
+	}
+	return appNames(keys), nil
+}
+
+// Versions implements Storage.
+func (s3 *S3Storage) Versions(name string, system ArchAndOS) ([]string, error) {
+	prefix := s3.opts.Prefix + name + "_"
+	suffix := "_" + system.Arch + system.OS
+	keys, err := s3.list(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects of %s: %v", name, err)
+	}
+
+	var versions []string
+	for _, k := range keys {
+		if !strings.HasSuffix(k, suffix) {
+			continue
+		}
+		v := strings.TrimSuffix(strings.TrimPrefix(k, prefix), suffix)
+		// skip artifacts of other applications whose names start with name_
+		if strings.Contains(v, "_") {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// Open implements Storage.
+func (s3 *S3Storage) Open(u *Update) (io.ReadCloser, error) {
+	return s3.get(s3.key(u))
+}
+
+// Stat implements Storage.
+func (s3 *S3Storage) Stat(u *Update) (*ArtifactInfo, error) {
+	key := s3.key(u)
+	resp, err := s3.do("HEAD", key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(errBinaryNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error("HEAD", key, resp)
+	}
+	info := &ArtifactInfo{Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// OpenRange implements rangeOpener with a range request, such that
+// resumed downloads are streamed from the bucket.
+func (s3 *S3Storage) OpenRange(u *Update, offset int64) (io.ReadCloser, error) {
+	key := s3.key(u)
+	if offset == 0 {
+		return s3.get(key)
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := s3.do("GET", key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errors.Wrap(errBinaryNotFound, key)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, s3Error("GET", key, resp)
+	}
+	return resp.Body, nil
+}
+
+// OpenSidecar implements Storage.
+func (s3 *S3Storage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	return s3.get(s3.key(u) + "." + ext)
+}
+
+// Put implements Storage. The data is verified against the sha256
+// sidecar before anything is written. Objects are written one by one,
+// the artifact as the last one, such that it is only visible after
+// all sidecars exist. Concurrent uploads of the same artifact are
+// excluded by a lock object and the artifact is only written, if it
+// does not exist, such that sidecars of one upload can not be
+// published with the artifact of another one.
+func (s3 *S3Storage) Put(u *Update, data io.Reader, sidecars map[string][]byte) error {
+	key := s3.key(u)
+	unlock, err := s3.lock(u)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	exists, err := s3.exists(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.Wrap(errArtifactExists, u.String())
+	}
+
+	body, size, digest, cleanup, err := sizedReader(data)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err = checkDigest(u, sidecars, digest); err != nil {
+		return err
+	}
+
+	for ext, b := range sidecars {
+		if err := s3.put(key+"."+ext, bytes.NewReader(b), int64(len(b))); err != nil {
+			return err
+		}
+	}
+	created, err := s3.create(key, body, size)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errors.Wrap(errArtifactExists, u.String())
+	}
+	return s3.verifySidecars(key, sidecars)
+}
+
+// verifySidecars writes the sidecars of the artifact key again, which
+// were replaced by another upload in between.
+func (s3 *S3Storage) verifySidecars(key string, sidecars map[string][]byte) error {
+	for ext, b := range sidecars {
+		rc, err := s3.get(key + "." + ext)
+		if err != nil {
+			return err
+		}
+		stored, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s.%s: %v", key, ext, err)
+		}
+		if bytes.Equal(stored, b) {
+			continue
+		}
+		glog.Warningf("Sidecar %s.%s was replaced by another upload", key, ext)
+		if err := s3.put(key+"."+ext, bytes.NewReader(b), int64(len(b))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock creates the lock object of the artifact of u and returns a
+// function to remove it. The lock object is written again every
+// lockRefresh while the upload is in progress, such that a lock
+// object left by an interrupted upload is detected by its age and
+// broken.
+func (s3 *S3Storage) lock(u *Update) (func(), error) {
+	key := s3.opts.Prefix + "." + u.String() + ".lock"
+	created, err := s3.create(key, bytes.NewReader(nil), 0)
+	if err == nil && !created && s3.breakStaleLock(key) {
+		created, err = s3.create(key, bytes.NewReader(nil), 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %v", u, err)
+	}
+	if !created {
+		return nil, errors.Wrapf(errArtifactExists, "%s is being uploaded", u)
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s3.put(key, bytes.NewReader(nil), 0); err != nil {
+					glog.Warningf("Failed to refresh lock of %s: %v", u, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if err := s3.delete(key); err != nil {
+			glog.Warningf("Failed to unlock %s: %v", u, err)
+		}
+	}, nil
+}
+
+// breakStaleLock removes the lock object key, if it was not written
+// for staleLockAge, and reports whether the lock can be taken again.
+func (s3 *S3Storage) breakStaleLock(key string) bool {
+	resp, err := s3.do("HEAD", key, nil, nil, nil, 0)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return true
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if resp.StatusCode != http.StatusOK || err != nil || s3.now().Sub(modified) < staleLockAge {
+		return false
+	}
+	if err := s3.delete(key); err != nil {
+		return false
+	}
+	glog.Warningf("Removed stale lock %s of an interrupted upload", key)
+	return true
+}
+
+// PutSidecar implements Storage.
+func (s3 *S3Storage) PutSidecar(u *Update, ext string, data []byte) error {
+	return s3.put(s3.key(u)+"."+ext, bytes.NewReader(data), int64(len(data)))
+}
+
+// Delete implements Storage.
+func (s3 *S3Storage) Delete(u *Update) error {
+	key := s3.key(u)
+	if err := s3.delete(key); err != nil {
+		return err
+	}
+	sidecars, err := s3.list(key + ".")
+	if err != nil {
+		return fmt.Errorf("could not list sidecars of %s: %v", key, err)
+	}
+	for _, k := range sidecars {
+		if err := s3.delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizedReader returns a reader with known size and the SHA256 digest
+// of r. Readers of unknown size are spooled to a temporary file,
+// because S3 requires a Content-Length for PUT requests. Caller has to
+// call cleanup.
+func sizedReader(r io.Reader) (io.Reader, int64, []byte, func(), error) {
+	noop := func() {}
+	var seeker io.ReadSeeker
+	switch v := r.(type) {
+	case *bytes.Reader:
+		seeker = v
+	case *bytes.Buffer:
+		b := v.Bytes()
+		digest := sha256.Sum256(b)
+		return bytes.NewReader(b), int64(len(b)), digest[:], noop, nil
+	case *strings.Reader:
+		seeker = v
+	case *os.File:
+		if fi, err := v.Stat(); err == nil && fi.Mode().IsRegular() {
+			seeker = v
+		}
+	}
+	h := sha256.New()
+	if seeker != nil {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, nil, noop, fmt.Errorf("failed to seek data: %v", err)
+		}
+		n, err := io.Copy(h, seeker)
+		if err == nil {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			return nil, 0, nil, noop, fmt.Errorf("failed to read data: %v", err)
+		}
+		return seeker, n, h.Sum(nil), noop, nil
+	}
+
+	fd, err := ioutil.TempFile("", "binary-patch-upload")
+	if err != nil {
+		return nil, 0, nil, noop, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	cleanup := func() {
+		fd.Close()
+		os.Remove(fd.Name())
+	}
+	n, err := io.Copy(io.MultiWriter(fd, h), r)
+	if err == nil {
+		_, err = fd.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, noop, fmt.Errorf("failed to spool data: %v", err)
+	}
+	return fd, n, h.Sum(nil), cleanup, nil
+}
+
+// Recover implements recoverer. It removes sidecars without artifact
+// and lock objects older than staleUploadAge, which are left if an
+// upload was interrupted. Younger sidecars may belong to an upload in
+// progress on another replica.
+func (s3 *S3Storage) Recover() ([]string, error) {
+	objects, err := s3.listObjects(s3.opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects: %v", err)
+	}
+	keys := make([]string, len(objects))
+	modified := make(map[string]time.Time, len(objects))
+	for i, o := range objects {
+		keys[i] = strings.TrimPrefix(o.Key, s3.opts.Prefix)
+		modified[keys[i]] = o.LastModified
+	}
+	stale := orphanSidecars(keys)
+	for _, name := range keys {
+		if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".lock") {
+			stale = append(stale, name)
+		}
+	}
+	var removed []string
+	for _, name := range stale {
+		if s3.now().Sub(modified[name]) < staleUploadAge {
+			continue
+		}
+		if err := s3.delete(s3.opts.Prefix + name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+func (s3 *S3Storage) get(key string) (io.ReadCloser, error) {
+	resp, err := s3.do("GET", key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errors.Wrap(errBinaryNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error("GET", key, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s3 *S3Storage) exists(key string) (bool, error) {
+	resp, err := s3.do("HEAD", key, nil, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3Error("HEAD", key, resp)
+}
+
+func (s3 *S3Storage) put(key string, body io.Reader, size int64) error {
+	resp, err := s3.do("PUT", key, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("PUT", key, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// create writes the object key, if it does not exist, and reports
+// whether it was written.
+func (s3 *S3Storage) create(key string, body io.Reader, size int64) (bool, error) {
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+	resp, err := s3.do("PUT", key, nil, header, body, size)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		resp.Body.Close()
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		resp.Body.Close()
+		return false, nil
+	}
+	return false, s3Error("PUT", key, resp)
+}
+
+func (s3 *S3Storage) delete(key string) error {
+	resp, err := s3.do("DELETE", key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("DELETE", key, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListBucketResult struct {
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+	Contents              []s3Object `xml:"Contents"`
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list returns all keys starting with prefix.
+func (s3 *S3Storage) list(prefix string) ([]string, error) {
+	objects, err := s3.listObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, o := range objects {
+		keys[i] = o.Key
+	}
+	return keys, nil
+}
+
+// listObjects returns all objects, whose key starts with prefix.
+func (s3 *S3Storage) listObjects(prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s3.do("GET", "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, s3Error("LIST", prefix, resp)
+		}
+		var result s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list of %s: %v", prefix, err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func s3Error(method, key string, resp *http.Response) error {
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s failed with status code %d: %s", method, key, resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// do sends a signed request for key in the configured bucket with the
+// additional header.
+func (s3 *S3Storage) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	rawURL := s3.opts.Endpoint + "/" + s3URIEncode(s3.opts.Bucket, false)
+	if key != "" {
+		rawURL += "/" + s3URIEncode(key, false)
+	}
+	if len(query) > 0 {
+		rawURL += "?" + s3CanonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := s3EmptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = s3UnsignedPayload
+	}
+	s3.sign(req, payloadHash)
+	return s3.opts.Client.Do(req)
+}
+
+// sign adds an AWS signature version 4 Authorization header to req.
+func (s3 *S3Storage) sign(req *http.Request, payloadHash string) {
+	now := s3.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s3.opts.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s3.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s3.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	signingKey := []byte("AWS4" + s3.opts.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s3.opts.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3URIEncode encodes s as required by AWS signature version 4. "/" is
+// only encoded if encodeSlash is set.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,389 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// memStorage is an in-memory Storage used to test handlers.
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (ms *memStorage) Apps() ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var names []string
+	for k := range ms.files {
+		names = append(names, k)
+	}
+	return appNames(names), nil
+}
+
+func (ms *memStorage) Stat(u *Update) (*ArtifactInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b, ok := ms.files[u.String()]
+	if !ok {
+		return nil, errors.Wrap(errBinaryNotFound, u.String())
+	}
+	return &ArtifactInfo{Size: int64(len(b))}, nil
+}
+
+func (ms *memStorage) Versions(name string, system ArchAndOS) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	prefix := name + "_"
+	suffix := "_" + system.Arch + system.OS
+	var versions []string
+	for k := range ms.files {
+		if !strings.HasPrefix(k, prefix) || !strings.HasSuffix(k, suffix) {
+			continue
+		}
+		v := strings.TrimSuffix(strings.TrimPrefix(k, prefix), suffix)
+		if strings.Contains(v, "_") {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+func (ms *memStorage) get(key string) (io.ReadCloser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b, ok := ms.files[key]
+	if !ok {
+		return nil, errors.Wrap(errBinaryNotFound, key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (ms *memStorage) Open(u *Update) (io.ReadCloser, error) {
+	return ms.get(u.String())
+}
+
+func (ms *memStorage) OpenSidecar(u *Update, ext string) (io.ReadCloser, error) {
+	return ms.get(u.String() + "." + ext)
+}
+
+func (ms *memStorage) Put(u *Update, data io.Reader, sidecars map[string][]byte) error {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.files[u.String()]; ok {
+		return errors.Wrap(errArtifactExists, u.String())
+	}
+	for ext, sidecar := range sidecars {
+		ms.files[u.String()+"."+ext] = sidecar
+	}
+	ms.files[u.String()] = b
+	return nil
+}
+
+func (ms *memStorage) PutSidecar(u *Update, ext string, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.files[u.String()+"."+ext] = data
+	return nil
+}
+
+func (ms *memStorage) Delete(u *Update) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for k := range ms.files {
+		if k == u.String() || strings.HasPrefix(k, u.String()+".") {
+			delete(ms.files, k)
+		}
+	}
+	return nil
+}
+
+func newTestUpdate(version string) *Update {
+	return &Update{
+		Name:    "foo",
+		Version: version,
+		System:  ArchAndOS{Arch: "amd64", OS: "linux"},
+	}
+}
+
+func newTestContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	return newTestRequestContext("GET", target, "")
+}
+
+// newTestRequestContext returns a context for application foo. A
+// non-empty body is sent as JSON.
+func newTestRequestContext(method, target, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.ReleaseMode)
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		ctx.Request.Header.Set("Content-Type", "application/json")
+	}
+	ctx.Params = gin.Params{{Key: "name", Value: "foo"}}
+	return ctx, w
+}
+
+func testStorage(t *testing.T, store Storage) {
+	u := newTestUpdate("v0.0.1")
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("binary")))
+	sidecars := map[string][]byte{sidecarSHA256: []byte(digest)}
+	if err := store.Put(u, strings.NewReader("binary"), sidecars); err != nil {
+		t.Fatalf("Failed to put %s: %v", u, err)
+	}
+	if err := store.Put(u, strings.NewReader("binary"), sidecars); err == nil {
+		t.Fatalf("Put of existing %s did not fail", u)
+	}
+	if err := store.Put(&Update{Name: "foo_bar", Version: "v0.0.2", System: u.System}, strings.NewReader("other"), nil); err != nil {
+		t.Fatalf("Failed to put foo_bar: %v", err)
+	}
+
+	versions, err := store.Versions(u.Name, u.System)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 1 || versions[0] != "v0.0.1" {
+		t.Fatalf("Wrong versions: %v", versions)
+	}
+	apps, err := store.Apps()
+	if err != nil || len(apps) != 2 || apps[0] != "foo" || apps[1] != "foo_bar" {
+		t.Fatalf("Wrong apps %v: %v", apps, err)
+	}
+	info, err := store.Stat(u)
+	if err != nil || info.Size != int64(len("binary")) {
+		t.Fatalf("Wrong stat of %s %+v: %v", u, info, err)
+	}
+
+	rc, err := store.Open(u)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", u, err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "binary" {
+		t.Fatalf("Wrong content: %s", b)
+	}
+	b, err = readSidecar(store, u, sidecarSHA256)
+	if err != nil || string(b) != digest {
+		t.Fatalf("Wrong sidecar %s: %v", b, err)
+	}
+	if _, err = store.OpenSidecar(u, sidecarSignature); err == nil {
+		t.Fatal("Missing sidecar found")
+	}
+	if err = store.PutSidecar(u, sidecarSHA256, []byte("def")); err != nil {
+		t.Fatalf("Failed to replace sidecar: %v", err)
+	}
+	b, err = readSidecar(store, u, sidecarSHA256)
+	if err != nil || string(b) != "def" {
+		t.Fatalf("Wrong replaced sidecar %s: %v", b, err)
+	}
+
+	if err = store.Delete(u); err != nil {
+		t.Fatalf("Failed to delete %s: %v", u, err)
+	}
+	if _, err = store.Open(u); err == nil {
+		t.Fatalf("Deleted %s found", u)
+	}
+	if _, err = store.OpenSidecar(u, sidecarSHA256); err == nil {
+		t.Fatalf("Deleted sidecar of %s found", u)
+	}
+}
+
+// testRecover checks that data of interrupted uploads is not
+// published and removed by Recover.
+// testRecover tests the recovery of store, age makes the file or
+// object name older than staleUploadAge.
+func testRecover(t *testing.T, store interface {
+	Storage
+	recoverer
+}, age func(name string)) {
+	u := newTestUpdate("v0.1.0")
+	sidecars := map[string][]byte{sidecarSHA256: []byte("corrupted"), sidecarRelease: []byte("{}")}
+	if err := store.Put(u, strings.NewReader("binary"), sidecars); err == nil {
+		t.Fatal("Put of data not matching the digest succeeded")
+	}
+	if _, err := store.Stat(u); err == nil {
+		t.Fatal("Corrupted upload was published")
+	}
+	if _, err := store.OpenSidecar(u, sidecarRelease); err == nil {
+		t.Fatal("Sidecar of corrupted upload was published")
+	}
+
+	// sidecars of an interrupted upload without artifact
+	if err := store.PutSidecar(u, sidecarRelease, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	// the upload may be in progress on another replica
+	removed, err := store.Recover()
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("Removed sidecars of a fresh upload %v: %v", removed, err)
+	}
+	age(u.String() + "." + sidecarRelease)
+	removed, err = store.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != u.String()+"."+sidecarRelease {
+		t.Fatalf("Wrong removed files: %v", removed)
+	}
+	if _, err := store.OpenSidecar(u, sidecarRelease); err == nil {
+		t.Fatal("Orphaned sidecar was not removed")
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStorage(t, NewFileStorage(dir))
+	testRecover(t, NewFileStorage(dir), func(name string) {
+		stale := time.Now().Add(-2 * staleUploadAge)
+		os.Chtimes(filepath.Join(dir, name), stale, stale)
+	})
+
+	// temporary files of interrupted uploads are removed, when they
+	// are stale
+	tmp := filepath.Join(dir, ".foo_v0.2.0_amd64linux.123")
+	if err := ioutil.WriteFile(tmp, []byte("bin"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := NewFileStorage(dir).Recover(); err != nil || len(removed) != 0 {
+		t.Fatalf("Removed fresh temporary file %v: %v", removed, err)
+	}
+	stale := time.Now().Add(-2 * staleUploadAge)
+	if err := os.Chtimes(tmp, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := NewFileStorage(dir).Recover(); err != nil || len(removed) != 1 {
+		t.Fatalf("Stale temporary file not removed %v: %v", removed, err)
+	}
+
+	// concurrent uploads of the same artifact are refused
+	u := newTestUpdate("v0.3.0")
+	lock := filepath.Join(dir, "."+u.String()+".lock")
+	if err := ioutil.WriteFile(lock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFileStorage(dir).Put(u, strings.NewReader("binary"), nil); errors.Cause(err) != errArtifactExists {
+		t.Fatalf("Put during an upload of the same artifact: %v", err)
+	}
+	if _, err := NewFileStorage(dir).Stat(u); err == nil {
+		t.Fatal("Artifact published during an upload of the same artifact")
+	}
+	// the lock of an interrupted upload is broken, when it is stale
+	stale = time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(lock, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFileStorage(dir).Put(u, strings.NewReader("binary"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatalf("Lock file not removed: %v", err)
+	}
+
+	// keys outside of the directory are refused
+	evil := &Update{Name: "foo", Version: "v0.4.0", System: ArchAndOS{Arch: "/../../outside/evil", OS: "linux"}}
+	if err := NewFileStorage(dir).Put(evil, strings.NewReader("binary"), nil); errors.Cause(err) != errInvalidKey {
+		t.Fatalf("Put of %s: %v", evil, err)
+	}
+	evil.System.Arch = ".."
+	if err := NewFileStorage(dir).PutSidecar(evil, sidecarRelease, nil); errors.Cause(err) != errInvalidKey {
+		t.Fatalf("PutSidecar of %s: %v", evil, err)
+	}
+}
+
+func TestMemStorage(t *testing.T) {
+	testStorage(t, newMemStorage())
+}
+
+func TestUpdateHandler(t *testing.T) {
+	store := newMemStorage()
+	for _, v := range []string{"v0.0.1", "v0.0.2"} {
+		if err := store.Put(newTestUpdate(v), strings.NewReader("binary "+v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{Healthy: true, Storage: store}
+
+	ctx, w := newTestContext("/update/foo?version=v0.0.1&arch=amd64&os=linux")
+	svc.UpdateHandler(ctx)
+	if w.Code != 200 || w.Body.String() != "binary v0.0.2" {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+
+	// resume download of the same artifact
+	if err := store.PutSidecar(newTestUpdate("v0.0.2"), sidecarSHA256, []byte("abc\n")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, w = newTestContext("/update/foo?version=v0.0.1&arch=amd64&os=linux")
+	ctx.Request.Header.Set("Range", "bytes=7-")
+	ctx.Request.Header.Set("If-Range", `"abc"`)
+	svc.UpdateHandler(ctx)
+	if w.Code != 206 || w.Body.String() != "v0.0.2" || w.Header().Get("ETag") != `"abc"` {
+		t.Fatalf("Wrong range response %d %s: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+
+	// the artifact changed, send the full binary
+	ctx, w = newTestContext("/update/foo?version=v0.0.1&arch=amd64&os=linux")
+	ctx.Request.Header.Set("Range", "bytes=7-")
+	ctx.Request.Header.Set("If-Range", `"other"`)
+	svc.UpdateHandler(ctx)
+	if w.Code != 200 || w.Body.String() != "binary v0.0.2" {
+		t.Fatalf("Wrong response for changed artifact %d: %s", w.Code, w.Body.String())
+	}
+
+	ctx, w = newTestContext("/update/foo?version=v0.0.2&arch=amd64&os=linux")
+	svc.UpdateHandler(ctx)
+	if ctx.Writer.Status() != 304 {
+		t.Fatalf("Wrong status code %d", ctx.Writer.Status())
+	}
+
+	ctx, _ = newTestContext("/update/foo?version=v0.0.1&arch=arm&os=linux")
+	svc.UpdateHandler(ctx)
+	if ctx.Writer.Status() != 400 {
+		t.Fatalf("Wrong status code %d", ctx.Writer.Status())
+	}
+}
+
+func TestService_checkReleases(t *testing.T) {
+	store := newMemStorage()
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("binary")))
+	if err := store.Put(newTestUpdate("v0.0.1"), strings.NewReader("binary"), map[string][]byte{sidecarSHA256: []byte(digest)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(newTestUpdate("v0.0.2"), strings.NewReader("binary"), nil); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{Healthy: true, Storage: store}
+	incomplete, err := svc.checkReleases()
+	if err != nil || len(incomplete) != 1 || incomplete[0].Version != "v0.0.2" {
+		t.Fatalf("Wrong incomplete releases %v: %v", incomplete, err)
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxUploadSize is the maximum size of an uploaded binary, if
+// not configured otherwise.
+const defaultMaxUploadSize = 1 << 30
+
+// uploadFields are the names of the metadata of an upload in JSON,
+// multipart form fields and, prefixed by "X-", in headers of raw
+// uploads.
+var uploadFields = []string{"version", "arch", "os", "signature", "signature-type", "channel", "rollout", "release-notes-url", "mandatory", "sha256"}
+
+var errUploadTooLarge = errors.New("Upload exceeds the maximum size")
+
+func maxUploadSize() int64 {
+	if cfg == nil || cfg.MaxUploadSize <= 0 {
+		return defaultMaxUploadSize
+	}
+	return cfg.MaxUploadSize
+}
+
+// isBodyTooLarge returns true, if err is returned by a reader of
+// http.MaxBytesReader, which exceeded its limit.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// setField sets the metadata field key of the upload, see
+// uploadFields.
+func (ud *UploadData) setField(key, value string) error {
+	var err error
+	switch key {
+	case "version":
+		ud.Version = value
+	case "arch":
+		ud.Architecture = value
+	case "os":
+		ud.OS = value
+	case "signature":
+		ud.Signature, err = base64.StdEncoding.DecodeString(value)
+	case "signature-type":
+		ud.SignatureType = value
+	case "channel":
+		ud.Channel = value
+	case "rollout":
+		var rollout int
+		rollout, err = strconv.Atoi(value)
+		ud.Rollout = &rollout
+	case "release-notes-url":
+		ud.ReleaseNotes = value
+	case "mandatory":
+		ud.Mandatory, err = strconv.ParseBool(value)
+	case "sha256":
+		ud.SHA256 = value
+	default:
+		return fmt.Errorf("unknown field %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+// expectedDigest returns the hex encoded SHA256 digest the uploader
+// expects, sent as "sha256" field, Content-SHA256 header (hex) or
+// Digest header (RFC 3230, SHA-256 base64 encoded). It returns an
+// empty string, if no digest was sent.
+func expectedDigest(req *http.Request, ud *UploadData) (string, error) {
+	if ud.SHA256 != "" {
+		return strings.ToLower(strings.TrimSpace(ud.SHA256)), nil
+	}
+	if value := req.Header.Get("Content-SHA256"); value != "" {
+		return strings.ToLower(strings.TrimSpace(value)), nil
+	}
+	for _, value := range strings.Split(req.Header.Get("Digest"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "SHA-256") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid Digest header: %v", err)
+		}
+		return hex.EncodeToString(b), nil
+	}
+	return "", nil
+}
+
+// readRawUpload reads the metadata of an application/octet-stream
+// upload from the headers and spools the body.
+func readRawUpload(req *http.Request) (*UploadData, error) {
+	ud := &UploadData{}
+	for _, key := range uploadFields {
+		if value := req.Header.Get("X-" + key); value != "" {
+			if err := ud.setField(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := ud.spool(req.Body); err != nil {
+		return nil, err
+	}
+	return ud, nil
+}
+
+// readMultipartUpload reads the metadata of a multipart/form-data
+// upload from the form fields and spools the part "data" without
+// buffering the form in memory.
+func readMultipartUpload(req *http.Request) (*UploadData, error) {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	ud := &UploadData{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ud.Close()
+			return nil, err
+		}
+		if err = ud.readPart(part); err != nil {
+			ud.Close()
+			return nil, err
+		}
+	}
+	if ud.body == nil {
+		return nil, errors.New("missing form field data")
+	}
+	return ud, nil
+}
+
+func (ud *UploadData) readPart(part *multipart.Part) error {
+	defer part.Close()
+	if part.FormName() == "data" {
+		if ud.body != nil {
+			return errors.New("duplicate form field data")
+		}
+		return ud.spool(part)
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(part, 4096))
+	if err != nil {
+		return err
+	}
+	return ud.setField(part.FormName(), string(value))
+}
+
+// spool copies the binary from r to a temporary file and computes its
+// SHA256 digest on the fly. The file is removed by Close.
+func (ud *UploadData) spool(r io.Reader) error {
+	fd, err := ioutil.TempFile("", "binary-patch-upload")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	ud.body = fd
+	limit := maxUploadSize()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(fd, h), io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = errors.Wrapf(errUploadTooLarge, "%d bytes", limit)
+	}
+	if err == nil {
+		_, err = fd.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		ud.Close()
+		return err
+	}
+	ud.digest = h.Sum(nil)
+	return nil
+}
+
+// Close removes the spooled binary of a streamed upload.
+func (ud *UploadData) Close() error {
+	if ud.body == nil {
+		return nil
+	}
+	ud.body.Close()
+	err := os.Remove(ud.body.Name())
+	ud.body = nil
+	return err
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/szuecs/binary-patch/conf"
+)
+
+func TestUploadHandler_raw(t *testing.T) {
+	store := newMemStorage()
+	svc := &Service{Healthy: true, Storage: store}
+
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", "binary v0.0.1")
+	ctx.Request.Header.Set("Content-Type", "application/octet-stream")
+	ctx.Request.Header.Set("X-Version", "v0.0.1")
+	ctx.Request.Header.Set("X-Arch", "amd64")
+	ctx.Request.Header.Set("X-OS", "linux")
+	ctx.Request.Header.Set("X-Channel", "beta")
+	svc.UploadHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+
+	u := newTestUpdate("v0.0.1")
+	rc, err := store.Open(u)
+	if err != nil {
+		t.Fatalf("Failed to open upload: %v", err)
+	}
+	defer rc.Close()
+	if b, _ := ioutil.ReadAll(rc); string(b) != "binary v0.0.1" {
+		t.Fatalf("Wrong binary %q", b)
+	}
+	if release, err := readRelease(store, u); err != nil || release.Channel != "beta" {
+		t.Fatalf("Wrong release %+v: %v", release, err)
+	}
+	digest, err := readSidecar(store, u, sidecarSHA256)
+	if err != nil || string(digest) != fmt.Sprintf("%x", sha256.Sum256([]byte("binary v0.0.1"))) {
+		t.Fatalf("Wrong digest %s: %v", digest, err)
+	}
+}
+
+func TestUploadHandler_unsupported(t *testing.T) {
+	store := newMemStorage()
+	svc := &Service{Healthy: true, Storage: store}
+
+	for _, arch := range []string{"/../../outside/evil", "mips"} {
+		ctx, w := newTestRequestContext("PUT", "/upload/foo", "binary v0.0.1")
+		ctx.Request.Header.Set("Content-Type", "application/octet-stream")
+		ctx.Request.Header.Set("X-Version", "v0.0.1")
+		ctx.Request.Header.Set("X-Arch", arch)
+		ctx.Request.Header.Set("X-OS", "linux")
+		svc.UploadHandler(ctx)
+		if w.Code != 422 {
+			t.Fatalf("Wrong response for arch %q %d: %s", arch, w.Code, w.Body.String())
+		}
+	}
+	if apps, err := store.Apps(); err != nil || len(apps) != 0 {
+		t.Fatalf("Unsupported upload stored %v: %v", apps, err)
+	}
+}
+
+func TestUploadHandler_multipart(t *testing.T) {
+	store := newMemStorage()
+	svc := &Service{Healthy: true, Storage: store}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range map[string]string{"version": "v0.0.1", "arch": "amd64", "os": "linux", "rollout": "10"} {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("data", "foo")
+	fw.Write([]byte("binary v0.0.1"))
+	mw.Close()
+
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", body.String())
+	ctx.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	svc.UploadHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+	u := newTestUpdate("v0.0.1")
+	if release, err := readRelease(store, u); err != nil || release.Rollout != 10 {
+		t.Fatalf("Wrong release %+v: %v", release, err)
+	}
+
+	// unknown fields are rejected
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	mw.WriteField("versoin", "v0.0.2")
+	mw.Close()
+	ctx, w = newTestRequestContext("PUT", "/upload/foo", body.String())
+	ctx.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	svc.UploadHandler(ctx)
+	if w.Code != 422 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadHandler_maxSize(t *testing.T) {
+	defer func(c *conf.Config) { cfg = c }(cfg)
+	cfg = &conf.Config{MaxUploadSize: 4}
+	svc := &Service{Healthy: true, Storage: newMemStorage()}
+
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", strings.Repeat("x", 5))
+	ctx.Request.Header.Set("Content-Type", "application/octet-stream")
+	ctx.Request.Header.Set("X-Version", "v0.0.1")
+	ctx.Request.Header.Set("X-Arch", "amd64")
+	ctx.Request.Header.Set("X-OS", "linux")
+	svc.UploadHandler(ctx)
+	if w.Code != 413 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+
+	// JSON uploads are limited by the size of the binary and of the body
+	for _, size := range []int{5, 5000} {
+		upload := UploadData{Data: bytes.Repeat([]byte("x"), size), Version: "v0.0.1", Architecture: "amd64", OS: "linux"}
+		body, _ := json.Marshal(upload)
+		ctx, w = newTestRequestContext("PUT", "/upload/foo", string(body))
+		svc.UploadHandler(ctx)
+		if w.Code != 413 {
+			t.Fatalf("Wrong response for JSON upload of %d bytes %d: %s", size, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUploadHandler_expectedDigest(t *testing.T) {
+	data := []byte("binary v0.0.1")
+	sum := sha256.Sum256(data)
+	digest := fmt.Sprintf("%x", sum)
+	svc := &Service{Healthy: true, Storage: newMemStorage()}
+
+	for _, tc := range []struct {
+		version string
+		header  string
+		value   string
+		want    int
+	}{
+		{"v0.0.1", "Digest", "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]), 200},
+		{"v0.0.2", "Content-SHA256", digest, 200},
+		{"v0.0.3", "Content-SHA256", fmt.Sprintf("%x", sha256.Sum256([]byte("other"))), 422},
+		{"v0.0.4", "X-SHA256", "abc", 422},
+		{"v0.0.5", "Digest", "SHA-256=%%%", 422},
+	} {
+		ctx, w := newTestRequestContext("PUT", "/upload/foo", string(data))
+		ctx.Request.Header.Set("Content-Type", "application/octet-stream")
+		ctx.Request.Header.Set("X-Version", tc.version)
+		ctx.Request.Header.Set("X-Arch", "amd64")
+		ctx.Request.Header.Set("X-OS", "linux")
+		ctx.Request.Header.Set(tc.header, tc.value)
+		svc.UploadHandler(ctx)
+		if w.Code != tc.want {
+			t.Fatalf("Wrong response for %s %s: %d: %s", tc.header, tc.value, w.Code, w.Body.String())
+		}
+		if tc.want != 200 {
+			continue
+		}
+		var resp struct {
+			SHA256 string `json:"sha256"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.SHA256 != digest {
+			t.Fatalf("Wrong digest in response %s: %v", w.Body.String(), err)
+		}
+	}
+
+	// JSON uploads send the digest in the upload data
+	upload := UploadData{Data: data, Version: "v0.0.6", Architecture: "amd64", OS: "linux", SHA256: "abc"}
+	body, _ := json.Marshal(upload)
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", string(body))
+	svc.UploadHandler(ctx)
+	if w.Code != 422 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadHandler_keyIDNotSetByUploader(t *testing.T) {
+	store := newMemStorage()
+	svc := &Service{Healthy: true, Storage: store}
+
+	body := `{"data": "YmluYXJ5", "version": "v0.0.1", "arch": "amd64", "os": "linux", "key-id": "release"}`
+	ctx, w := newTestRequestContext("PUT", "/upload/foo", body)
+	svc.UploadHandler(ctx)
+	if w.Code != 200 {
+		t.Fatalf("Wrong response %d: %s", w.Code, w.Body.String())
+	}
+	if release, err := readRelease(store, newTestUpdate("v0.0.1")); err != nil || release.KeyID != "" {
+		t.Fatalf("Wrong release %+v: %v", release, err)
+	}
+}
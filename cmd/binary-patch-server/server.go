@@ -50,6 +50,12 @@ Example:
 	flag.StringVar(&serverConfig.TLSKeyfilePath, "tls-key", serverConfig.TLSKeyfilePath, "TLS Keyfile")
 	flag.IntVar(&serverConfig.Port, "port", serverConfig.Port, "Listening TCP Port of the service.")
 	flag.IntVar(&serverConfig.MonitorPort, "monitor-port", serverConfig.MonitorPort, "Listening TCP Port of the monitor.")
+	flag.StringVar(&serverConfig.StorageBackend, "storage", serverConfig.StorageBackend, "Storage backend to use: file or s3.")
+	flag.StringVar(&serverConfig.StorageDir, "storage-dir", serverConfig.StorageDir, "Directory to store binaries in.")
+	flag.StringVar(&serverConfig.S3Endpoint, "s3-endpoint", serverConfig.S3Endpoint, "S3 API endpoint, p.e. https://s3.eu-central-1.amazonaws.com")
+	flag.StringVar(&serverConfig.S3Region, "s3-region", serverConfig.S3Region, "S3 region")
+	flag.StringVar(&serverConfig.S3Bucket, "s3-bucket", serverConfig.S3Bucket, "S3 bucket to store binaries in.")
+	flag.StringVar(&serverConfig.S3Prefix, "s3-prefix", serverConfig.S3Prefix, "Prefix of all S3 object keys.")
 	flag.DurationVar(&serverConfig.LogFlushInterval, "flush-interval", serverConfig.LogFlushInterval, "Interval to flush Logs to disk.")
 
 	flag.Parse()
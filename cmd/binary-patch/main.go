@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/szuecs/binary-patch/patchclient"
 
@@ -19,12 +26,20 @@ var (
 
 	// public key to verify signed updates
 	publicKey []byte
+	// keyring to verify signed updates, if set public key is not used
+	keyring patchclient.Keyring
 )
 
 func main() {
 	var (
 		publicKeyFDptr **os.File
 		debug          = kingpin.Flag("debug", "enable debug mode").Default("false").Bool()
+		channel        = kingpin.Flag("channel", "Release channel to update from, p.e. stable, beta or nightly, defaults to the most stable channel of the application").String()
+		installID      = kingpin.Flag("install-id", "ID of this installation used for staged rollouts").String()
+		restart        = kingpin.Flag("restart", "Restart the updated binary after a successful update").Default("false").Bool()
+		timeout        = kingpin.Flag("timeout", "Timeout of requests to the server").Default("5m").Duration()
+		retries        = kingpin.Flag("retries", "Number of retries of broken downloads").Default("3").Int()
+		caCertFile     = kingpin.Flag("ca-cert", "File path containing PEM encoded CA certificates to verify the server").String()
 		_              = kingpin.Command("version", "show version")
 		update         = kingpin.Command("update", "update binary")
 		baseUpdateURL  = update.Flag("url", "Update URL").Default("http://localhost:8080/update").String()
@@ -38,8 +53,22 @@ func main() {
 
 		signedPatchUpdate        = signed.Command("patch-update", "Verify signature and update binary diff")
 		baseSignedPatchUpdateURL = signedPatchUpdate.Flag("url", "Update URL").Default("http://localhost:8080/signed-patch-update").String()
+
+		check        = kingpin.Command("check", "check for an update without downloading it")
+		baseCheckURL = check.Flag("url", "Check URL").Default("http://localhost:8080/check").String()
+
+		yank        = kingpin.Command("yank", "Mark a published version as yanked, deprecated, paused or active")
+		yankURL     = yank.Flag("url", "Yank URL").Default("http://localhost:8080/yank").String()
+		yankState   = yank.Flag("state", "State of the version").Default(patchclient.StateYanked).Enum(patchclient.StateYanked, patchclient.StateDeprecated, patchclient.StatePaused, patchclient.StateActive)
+		yankToken   = yank.Flag("token", "OAuth2 bearer token").Envar("BINARY_PATCH_TOKEN").String()
+		yankName    = yank.Arg("name", "Application name").Required().String()
+		yankVersion = yank.Arg("version", "Version to mark").Required().String()
 	)
 	publicKeyFDptr = signed.Flag("public-key", "File path containing the public Key used to verify signed updates.").File()
+	keyringFile := signed.Flag("keyring", "File path containing a JSON keyring of public keys used to verify signed updates.").String()
+	manifestURL := signed.Flag("manifest-url", "Manifest URL, if set signed updates have to be listed in the signed release manifest, p.e. http://localhost:8080/manifest").String()
+	allowDowngrade := signed.Flag("allow-downgrade", "Allow to install older versions without signed rollback authorization").Default("false").Bool()
+	manifestKeyFile := signed.Flag("manifest-public-key", "File path containing the public key used to verify the release manifest.").String()
 
 	cmd := kingpin.Parse()
 	if *debug {
@@ -53,6 +82,35 @@ func main() {
 			log.Fatalf("Failed to read %s: %v", fd.Name(), err)
 		}
 	}
+	if *keyringFile != "" {
+		kr, err := patchclient.LoadKeyring(*keyringFile)
+		if err != nil {
+			log.Fatalf("Failed to load keyring %s: %v", *keyringFile, err)
+		}
+		keyring = kr
+	}
+	var manifestPublicKey []byte
+	if *manifestKeyFile != "" {
+		buf, err := ioutil.ReadFile(*manifestKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *manifestKeyFile, err)
+		}
+		manifestPublicKey = buf
+	}
+
+	httpClient, err := newHTTPClient(*timeout, *caCertFile)
+	if err != nil {
+		log.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	progress := newProgress(os.Stdout)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
 
 	switch cmd {
 	case "version":
@@ -64,30 +122,155 @@ func main() {
 		os.Exit(0)
 	case update.FullCommand():
 		pc := patchclient.NewInsecurePatchClient(*baseUpdateURL, version)
-		err := pc.UnsignedNotVerifiedUpdate()
+		pc.Channel = *channel
+		pc.InstallID = *installID
+		pc.HTTPClient = httpClient
+		pc.Retries = *retries
+		pc.Progress = progress
+		err := pc.UnsignedNotVerifiedUpdate(ctx)
 		if err != nil {
 			log.Fatalf("Failed to update: %v", err)
 		}
+		restartUpdated(*restart)
 
 	case patchUpdate.FullCommand():
 		pc := patchclient.NewInsecurePatchClient(*basePatchUpdateURL, version)
-		err := pc.UnsignedNotVerifiedPatchUpdate()
+		pc.Channel = *channel
+		pc.InstallID = *installID
+		pc.HTTPClient = httpClient
+		pc.Retries = *retries
+		pc.Progress = progress
+		err := pc.UnsignedNotVerifiedPatchUpdate(ctx)
 		if err != nil {
 			log.Fatalf("Failed to update: %v", err)
 		}
+		restartUpdated(*restart)
 
 	case signedUpdate.FullCommand():
 		pc := patchclient.NewPatchClient(*baseSignedUpdateURL, version, publicKey)
-		err := pc.SignedVerifiedUpdate()
+		pc.Keyring = keyring
+		pc.ManifestURL = *manifestURL
+		pc.ManifestPublicKey = manifestPublicKey
+		pc.AllowDowngrade = *allowDowngrade
+		pc.Channel = *channel
+		pc.InstallID = *installID
+		pc.HTTPClient = httpClient
+		pc.Retries = *retries
+		pc.Progress = progress
+		err := pc.SignedVerifiedUpdate(ctx)
 		if err != nil {
 			log.Fatalf("Failed to update: %v", err)
 		}
+		restartUpdated(*restart)
+
+	case check.FullCommand():
+		pc := patchclient.NewInsecurePatchClient(*baseCheckURL, version)
+		pc.Channel = *channel
+		pc.InstallID = *installID
+		pc.HTTPClient = httpClient
+		pc.Retries = *retries
+		pc.Progress = progress
+		info, err := pc.CheckForUpdate(ctx)
+		if err != nil {
+			log.Fatalf("Failed to check for update: %v", err)
+		}
+		if !info.Available {
+			fmt.Printf("You have the latest version %s\n", info.CurrentVersion)
+			break
+		}
+		fmt.Printf("A new version %s is available (%d bytes", info.Version, info.Size)
+		if info.PatchSize > 0 {
+			fmt.Printf(", patch %d bytes", info.PatchSize)
+		}
+		fmt.Println(")")
+		if info.Mandatory {
+			fmt.Println("The update is mandatory")
+		}
+		if info.ReleaseNotesURL != "" {
+			fmt.Printf("Release notes: %s\n", info.ReleaseNotesURL)
+		}
+
+	case yank.FullCommand():
+		err := patchclient.SetReleaseState(ctx, httpClient, *yankURL, *yankName, *yankVersion, *yankState, *yankToken)
+		if err != nil {
+			log.Fatalf("Failed to mark %s %s as %s: %v", *yankName, *yankVersion, *yankState, err)
+		}
+		fmt.Printf("Marked %s %s as %s\n", *yankName, *yankVersion, *yankState)
 
 	case signedPatchUpdate.FullCommand():
 		pc := patchclient.NewPatchClient(*baseSignedPatchUpdateURL, version, publicKey)
-		err := pc.SignedVerifiedPatchUpdate()
+		pc.Keyring = keyring
+		pc.ManifestURL = *manifestURL
+		pc.ManifestPublicKey = manifestPublicKey
+		pc.AllowDowngrade = *allowDowngrade
+		pc.Channel = *channel
+		pc.InstallID = *installID
+		pc.HTTPClient = httpClient
+		pc.Retries = *retries
+		pc.Progress = progress
+		err := pc.SignedVerifiedPatchUpdate(ctx)
 		if err != nil {
 			log.Fatalf("Failed to update: %v", err)
 		}
+		restartUpdated(*restart)
+	}
+}
+
+// newHTTPClient returns a client with timeout, which trusts the CA
+// certificates in caCertFile in addition to the system roots, if set.
+// Proxies are configured by the environment, p.e. HTTPS_PROXY.
+func newHTTPClient(timeout time.Duration, caCertFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caCertFile != "" {
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// newProgress returns a progress indicator printed to out, if out is a
+// terminal, otherwise nil.
+func newProgress(out *os.File) patchclient.ProgressFunc {
+	fi, err := out.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	lastPhase := ""
+	return func(phase string, received, total int64) {
+		if phase != lastPhase && lastPhase != "" {
+			fmt.Fprintln(out)
+		}
+		lastPhase = phase
+		if total > 0 {
+			fmt.Fprintf(out, "\r%-8s %3d%% %d/%d bytes", phase, received*100/total, received, total)
+		} else {
+			fmt.Fprintf(out, "\r%-8s %d bytes", phase, received)
+		}
+		if phase == patchclient.PhaseApply || received == total {
+			fmt.Fprintln(out)
+			lastPhase = ""
+		}
+	}
+}
+
+// restartUpdated execs the updated binary, if restart is set. The
+// binary is restarted with the version command, because the same
+// arguments would run the update again.
+func restartUpdated(restart bool) {
+	if !restart {
+		return
+	}
+	if err := patchclient.RestartWith([]string{os.Args[0], "version"}); err != nil {
+		log.Fatalf("Failed to restart: %v", err)
 	}
 }
@@ -17,18 +17,89 @@ import (
 // Config is the configuration struct. The config file config.yaml
 // will unmarshaled to this struct.
 type Config struct {
-	DebugEnabled     bool                  `yaml:"debug_enabled,omitempty"`
-	Oauth2Enabled    bool                  `yaml:"oauth2_enabled,omitempty"`
-	ProfilingEnabled bool                  `yaml:"profiling_enabled,omitempty"`
-	Port             int                   `yaml:"port,omitempty"`
-	MonitorPort      int                   `yaml:"monitor_port,omitempty"`
-	LogFlushInterval time.Duration         `yaml:"log_flush_interval,omitempty"`
-	TLSCertfilePath  string                `yaml:"tls_certfile_path,omitempty"`
-	TLSKeyfilePath   string                `yaml:"tls_keyfile_path,omitempty"`
-	AuthURL          string                `yaml:"auth_url,omitempty"`
-	TokenURL         string                `yaml:"token_url,omitempty"`
-	AuthorizedTeams  []zalando.AccessTuple `yaml:"authorized_teams,omitempty"`
-	AuthorizedUsers  []zalando.AccessTuple `yaml:"authorized_users,omitempty"`
+	DebugEnabled     bool                   `yaml:"debug_enabled,omitempty"`
+	Oauth2Enabled    bool                   `yaml:"oauth2_enabled,omitempty"`
+	ProfilingEnabled bool                   `yaml:"profiling_enabled,omitempty"`
+	Port             int                    `yaml:"port,omitempty"`
+	MonitorPort      int                    `yaml:"monitor_port,omitempty"`
+	LogFlushInterval time.Duration          `yaml:"log_flush_interval,omitempty"`
+	TLSCertfilePath  string                 `yaml:"tls_certfile_path,omitempty"`
+	TLSKeyfilePath   string                 `yaml:"tls_keyfile_path,omitempty"`
+	AuthURL          string                 `yaml:"auth_url,omitempty"`
+	TokenURL         string                 `yaml:"token_url,omitempty"`
+	AuthorizedTeams  []zalando.AccessTuple  `yaml:"authorized_teams,omitempty"`
+	AuthorizedUsers  []zalando.AccessTuple  `yaml:"authorized_users,omitempty"`
+	StorageBackend   string                 `yaml:"storage_backend,omitempty"`
+	StorageDir       string                 `yaml:"storage_dir,omitempty"`
+	S3Endpoint       string                 `yaml:"s3_endpoint,omitempty"`
+	S3Region         string                 `yaml:"s3_region,omitempty"`
+	S3Bucket         string                 `yaml:"s3_bucket,omitempty"`
+	S3Prefix         string                 `yaml:"s3_prefix,omitempty"`
+	Applications     map[string]Application `yaml:"applications,omitempty"`
+	// ReleaseCacheTTL is the time the versions and release metadata
+	// of applications are cached, defaults to 10s. Changes by other
+	// replicas are seen after this time.
+	ReleaseCacheTTL time.Duration `yaml:"release_cache_ttl,omitempty"`
+	// PatchCacheVersions is the number of previous versions to
+	// create patches from, when a new version is uploaded.
+	PatchCacheVersions int `yaml:"patch_cache_versions,omitempty"`
+	// ManifestKeyPath is the PEM encoded private key used to sign
+	// release manifests. Manifests are disabled if not set.
+	ManifestKeyPath string `yaml:"manifest_key_path,omitempty"`
+	// ManifestExpiry is the lifetime of a signed manifest, defaults
+	// to 24h.
+	ManifestExpiry time.Duration `yaml:"manifest_expiry,omitempty"`
+	// MaxUploadSize is the maximum size of an uploaded binary in
+	// bytes, defaults to 1 GiB.
+	MaxUploadSize int64 `yaml:"max_upload_size,omitempty"`
+	// RolloutHaltThreshold is the ratio of installations reporting
+	// failed updates, p.e. 0.05, which pauses a release
+	// automatically. Disabled if not set.
+	RolloutHaltThreshold float64 `yaml:"rollout_halt_threshold,omitempty"`
+	// RolloutHaltWindow is the time window of update reports, which
+	// are considered to halt a rollout, defaults to 1h.
+	RolloutHaltWindow time.Duration `yaml:"rollout_halt_window,omitempty"`
+	// RolloutHaltMinReports is the minimum number of installations
+	// reporting within the window to halt a rollout, defaults to 10.
+	RolloutHaltMinReports int `yaml:"rollout_halt_min_reports,omitempty"`
+}
+
+// Application is the configuration of one application served by the
+// service.
+type Application struct {
+	// Channels are the release channels of the application ordered
+	// from most to least stable, p.e. [stable, beta, nightly].
+	Channels []string `yaml:"channels,omitempty"`
+	// PublicKeys are trusted to sign releases of the application. If
+	// set, uploads have to be signed by one of them.
+	PublicKeys []PublicKey `yaml:"public_keys,omitempty"`
+}
+
+// PublicKey is a PEM encoded public key used to verify signatures.
+type PublicKey struct {
+	// ID identifies the key, p.e. release-2017
+	ID string `yaml:"id"`
+	// Path of the PEM file
+	Path string `yaml:"path"`
+	// SignedBy is the ID of the key, which created the
+	// CrossSignature of this key to rotate keys.
+	SignedBy string `yaml:"signed_by,omitempty"`
+	// CrossSignature is the path of the signature of this key
+	// created by the key SignedBy, see signature.VerifyKey.
+	CrossSignature string `yaml:"cross_signature,omitempty"`
+}
+
+// DefaultChannels are used for applications without configured
+// channels.
+var DefaultChannels = []string{"stable", "beta", "nightly"}
+
+// Channels returns the release channels of application ordered from
+// most to least stable.
+func (c *Config) Channels(application string) []string {
+	if app, ok := c.Applications[application]; ok && len(app.Channels) > 0 {
+		return app.Channels
+	}
+	return DefaultChannels
 }
 
 // shared state for configuration
@@ -1,6 +1,6 @@
 module github.com/szuecs/binary-patch
 
-go 1.12
+go 1.15
 
 require (
 	github.com/DeanThompson/ginpprof v0.0.0-20170218162546-8c0e31bfeaa8
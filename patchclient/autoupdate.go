@@ -0,0 +1,250 @@
+package patchclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+// DefaultCheckInterval is the interval of an AutoUpdater without
+// configured Interval.
+const DefaultCheckInterval = time.Hour
+
+// AutoUpdater periodically checks for updates, downloads and verifies
+// them in the background and stages the new binary. The staged binary
+// is applied on request or on the next start of the application by
+// ApplyStaged. Client has to be a PatchClient for signed full updates,
+// p.e. with URL http://localhost:8080/signed-update.
+type AutoUpdater struct {
+	Client *PatchClient
+	// Interval between two checks, defaults to DefaultCheckInterval
+	Interval time.Duration
+	// Jitter is the maximum random delay added to Interval, such
+	// that not all clients check at the same time.
+	Jitter time.Duration
+	// StagingPath is the file the verified binary is staged to,
+	// defaults to the path of the executable with suffix ".staged".
+	// The metadata of the update is stored next to it with suffix
+	// ".json".
+	StagingPath string
+
+	// OnUpdateAvailable is called, if the server offers an update.
+	OnUpdateAvailable func(info *UpdateInfo)
+	// OnUpdateStaged is called, if an update was downloaded,
+	// verified and staged.
+	OnUpdateStaged func(version string)
+	// OnUpdateFailed is called, if checking, downloading or verifying
+	// an update failed.
+	OnUpdateFailed func(err error)
+
+	mu sync.Mutex
+}
+
+// NewAutoUpdater returns an AutoUpdater with default interval and 10%
+// jitter for the signed update client pc.
+func NewAutoUpdater(pc *PatchClient) *AutoUpdater {
+	return &AutoUpdater{
+		Client:   pc,
+		Interval: DefaultCheckInterval,
+		Jitter:   DefaultCheckInterval / 10,
+	}
+}
+
+func (au *AutoUpdater) stagingPath() (string, error) {
+	if au.StagingPath != "" {
+		return au.StagingPath, nil
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find executable: %v", err)
+	}
+	return binary + ".staged", nil
+}
+
+func (au *AutoUpdater) wait() time.Duration {
+	interval := au.Interval
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	if au.Jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(au.Jitter)))
+	}
+	return interval
+}
+
+// Run checks for updates until ctx is done. The first check is done
+// immediately. Errors are reported to OnUpdateFailed.
+func (au *AutoUpdater) Run(ctx context.Context) error {
+	for {
+		if err := au.CheckNow(ctx); err != nil && au.OnUpdateFailed != nil {
+			au.OnUpdateFailed(err)
+		}
+		timer := time.NewTimer(au.wait())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// CheckNow checks for an update and stages it, if available. It
+// returns nil, if there is no update or the update is already staged.
+func (au *AutoUpdater) CheckNow(ctx context.Context) error {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+
+	info, err := au.Client.CheckForUpdate(ctx)
+	if err != nil {
+		return err
+	}
+	if !info.Available {
+		return nil
+	}
+	if au.OnUpdateAvailable != nil {
+		au.OnUpdateAvailable(info)
+	}
+	if staged, err := au.staged(); err == nil && staged.Version == info.Version {
+		return nil
+	}
+
+	data, err := au.Client.getSignedUpdate(ctx)
+	if err != nil {
+		return err
+	}
+	if err := au.Client.verifySignedUpdate(data); err != nil {
+		return err
+	}
+	if err := au.stage(data); err != nil {
+		return err
+	}
+	if au.OnUpdateStaged != nil {
+		au.OnUpdateStaged(data.Version)
+	}
+	return nil
+}
+
+// verifySignedUpdate checks digest and signature of the full binary
+// in data.
+func (pc *PatchClient) verifySignedUpdate(data SignedUpdate) error {
+	checksum, pub, err := pc.signingKey(data)
+	if err != nil {
+		return err
+	}
+	actual := sha256.Sum256(data.Patch)
+	if !bytes.Equal(actual[:], checksum) {
+		return &DigestMismatchError{Subject: "update", Expected: fmt.Sprintf("%x", checksum), Actual: fmt.Sprintf("%x", actual)}
+	}
+	signatureType, err := signature.TypeOf(pub)
+	if err != nil {
+		return err
+	}
+	return signature.Verify(signatureType, pub, checksum, data.Signature)
+}
+
+// stage writes the binary of data to the staging path and its
+// metadata next to it. The metadata is written last, such that only
+// completely written binaries are applied.
+func (au *AutoUpdater) stage(data SignedUpdate) error {
+	fpath, err := au.stagingPath()
+	if err != nil {
+		return err
+	}
+	os.Remove(fpath + ".json")
+	if err := ioutil.WriteFile(fpath, data.Patch, 0600); err != nil {
+		return fmt.Errorf("failed to stage update: %v", err)
+	}
+	data.Patch = nil
+	meta, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(fpath+".json", meta, 0600); err != nil {
+		return fmt.Errorf("failed to stage update: %v", err)
+	}
+	return nil
+}
+
+// staged returns the metadata of the staged update.
+func (au *AutoUpdater) staged() (SignedUpdate, error) {
+	var data SignedUpdate
+	fpath, err := au.stagingPath()
+	if err != nil {
+		return data, err
+	}
+	meta, err := ioutil.ReadFile(fpath + ".json")
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(meta, &data); err != nil {
+		return data, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+	}
+	return data, nil
+}
+
+func (au *AutoUpdater) removeStaged() {
+	if fpath, err := au.stagingPath(); err == nil {
+		os.Remove(fpath + ".json")
+		os.Remove(fpath)
+	}
+}
+
+// ApplyStaged applies the staged update, if there is one, and returns
+// its version. Call it on start of the application or on request of
+// the user. The staged binary is verified again before it replaces
+// the executable. Staged updates, which are not newer than the
+// running version and not an authorized rollback, are discarded.
+func (au *AutoUpdater) ApplyStaged() (string, error) {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+
+	data, err := au.staged()
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		au.removeStaged()
+		return "", err
+	}
+	if err := au.Client.checkDowngrade(data); err != nil {
+		au.removeStaged()
+		if errors.Is(err, ErrDowngrade) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	fpath, err := au.stagingPath()
+	if err != nil {
+		return "", err
+	}
+	fd, err := os.Open(fpath)
+	if err != nil {
+		au.removeStaged()
+		return "", fmt.Errorf("failed to open staged update: %v", err)
+	}
+	checksum, pub, err := au.Client.signingKey(data)
+	if err != nil {
+		fd.Close()
+		au.removeStaged()
+		return "", err
+	}
+	err = au.Client.applyVerifiedUpdate(fd, nil, checksum, data.Signature, pub)
+	au.removeStaged()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ErrApplyUpdate, err)
+	}
+	return strings.TrimSpace(data.Version), nil
+}
@@ -0,0 +1,158 @@
+package patchclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestAutoUpdater_CheckNow(t *testing.T) {
+	priv, pubPEM := newTestKey(t)
+	binary := []byte("new binary")
+	digest := sha256.Sum256(binary)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := SignedUpdate{
+		Patch:         binary,
+		Signature:     sig,
+		Digest:        []byte(fmt.Sprintf("%x", digest)),
+		SignatureType: "ecdsa",
+		Version:       "v0.0.2",
+	}
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/check/" + GetLocalBinaryName():
+			w.Write([]byte(`{"update-available": true, "version": "v0.0.2", "current-version": "v0.0.1"}`))
+		case "/signed-update/" + GetLocalBinaryName():
+			downloads++
+			json.NewEncoder(w).Encode(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "autoupdate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	au := NewAutoUpdater(NewPatchClient(srv.URL+"/signed-update", "v0.0.1", []byte(pubPEM)))
+	au.StagingPath = filepath.Join(dir, "app.staged")
+	var available, staged string
+	au.OnUpdateAvailable = func(info *UpdateInfo) { available = info.Version }
+	au.OnUpdateStaged = func(version string) { staged = version }
+
+	if err := au.CheckNow(context.Background()); err != nil {
+		t.Fatalf("Failed to check for update: %v", err)
+	}
+	if available != "v0.0.2" || staged != "v0.0.2" {
+		t.Fatalf("Wrong callbacks, available: %q, staged: %q", available, staged)
+	}
+	if b, err := ioutil.ReadFile(au.StagingPath); err != nil || string(b) != string(binary) {
+		t.Fatalf("Wrong staged binary %q: %v", b, err)
+	}
+
+	// already staged updates are not downloaded again
+	if err := au.CheckNow(context.Background()); err != nil || downloads != 1 {
+		t.Fatalf("Failed to skip staged update, downloads: %d: %v", downloads, err)
+	}
+
+	// staged updates not newer than the running version are discarded
+	au.Client.Version = "v0.0.2"
+	if version, err := au.ApplyStaged(); err != nil || version != "" {
+		t.Fatalf("Applied outdated staged update %q: %v", version, err)
+	}
+	if _, err := os.Stat(au.StagingPath); !os.IsNotExist(err) {
+		t.Fatalf("Outdated staged update was not removed: %v", err)
+	}
+
+	// updates with bad signatures are not staged
+	au.Client.Version = "v0.0.1"
+	data.Patch = []byte("tampered binary")
+	if err := au.CheckNow(context.Background()); err == nil {
+		t.Fatal("Staged tampered update")
+	}
+	if _, err := os.Stat(au.StagingPath); !os.IsNotExist(err) {
+		t.Fatalf("Tampered update was staged: %v", err)
+	}
+}
+
+func TestAutoUpdater_ApplyStagedRollback(t *testing.T) {
+	priv, pubPEM := newTestKey(t)
+	binary := []byte("binary v0.0.2")
+	digest := sha256.Sum256(binary)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	toSHA256 := fmt.Sprintf("%x", digest)
+	rbSig, err := ecdsa.SignASN1(rand.Reader, priv, signature.RollbackDigest(GetLocalBinaryName(), "v0.0.3", "v0.0.2", toSHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := SignedUpdate{
+		Patch:         binary,
+		Signature:     sig,
+		Digest:        []byte(toSHA256),
+		SignatureType: "ecdsa",
+		Version:       "v0.0.2",
+		Rollback:      &Rollback{From: "v0.0.3", To: "v0.0.2", Signature: rbSig, SignatureType: "ecdsa"},
+	}
+
+	dir, err := ioutil.TempDir("", "autoupdate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(target, []byte("binary v0.0.3"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	au := NewAutoUpdater(NewPatchClient("http://localhost/signed-update", "v0.0.3", []byte(pubPEM)))
+	au.StagingPath = filepath.Join(dir, "app.staged")
+	au.Client.targetPath = target
+	if err := au.stage(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// authorized rollbacks are applied
+	if version, err := au.ApplyStaged(); err != nil || version != "v0.0.2" {
+		t.Fatalf("Failed to apply staged rollback %q: %v", version, err)
+	}
+	if b, err := ioutil.ReadFile(target); err != nil || string(b) != string(binary) {
+		t.Fatalf("Wrong binary %q: %v", b, err)
+	}
+	if _, err := os.Stat(au.StagingPath); !os.IsNotExist(err) {
+		t.Fatalf("Applied staged update was not removed: %v", err)
+	}
+
+	// rollbacks, which are not authorized for the running version,
+	// are discarded
+	au.Client.Version = "v0.0.4"
+	if err := au.stage(data); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := au.ApplyStaged(); err != nil || version != "" {
+		t.Fatalf("Applied unauthorized rollback %q: %v", version, err)
+	}
+	if _, err := os.Stat(au.StagingPath); !os.IsNotExist(err) {
+		t.Fatalf("Unauthorized rollback was not removed: %v", err)
+	}
+}
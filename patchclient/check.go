@@ -0,0 +1,56 @@
+package patchclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// UpdateInfo is the result of CheckForUpdate.
+type UpdateInfo struct {
+	// Available is true, if the server offers Version
+	Available      bool   `json:"update-available"`
+	Version        string `json:"version"`
+	CurrentVersion string `json:"current-version"`
+	// Size is the size of the full binary of Version
+	Size int64 `json:"size,omitempty"`
+	// PatchSize is the size of the binary patch, if the server has
+	// it cached
+	PatchSize       int64  `json:"patch-size,omitempty"`
+	ReleaseNotesURL string `json:"release-notes-url,omitempty"`
+	// Mandatory is set, if Version or a release between the running
+	// version and Version should not be skipped
+	Mandatory bool `json:"mandatory,omitempty"`
+}
+
+// CheckForUpdate asks the server, if an update is available, without
+// downloading it. The check endpoint is the sibling of URL, p.e.
+// http://localhost:8080/check for http://localhost:8080/update.
+func (pc *PatchClient) CheckForUpdate(ctx context.Context) (*UpdateInfo, error) {
+	checkURL, err := pc.endpointURL("check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check URL: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, pc.updateURL(checkURL).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pc.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if warning := resp.Header.Get("Warning"); warning != "" {
+		log.Printf("Warning: %s", warning)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to check for update with status code: %d", resp.StatusCode)
+	}
+	var info UpdateInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+	}
+	return &info, nil
+}
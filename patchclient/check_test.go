@@ -0,0 +1,34 @@
+package patchclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckForUpdate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/check/"+GetLocalBinaryName() || r.URL.Query().Get("version") != "v0.0.1" || r.URL.Query().Get("channel") != "beta" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"update-available": true, "version": "v0.0.2", "current-version": "v0.0.1", "size": 42, "mandatory": true}`))
+	}))
+	defer srv.Close()
+
+	pc := NewInsecurePatchClient(srv.URL+"/update", "v0.0.1")
+	pc.Channel = "beta"
+	info, err := pc.CheckForUpdate(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to check for update: %v", err)
+	}
+	if !info.Available || info.Version != "v0.0.2" || info.Size != 42 || !info.Mandatory {
+		t.Fatalf("Wrong update info: %+v", info)
+	}
+
+	pc.Version = "v0.0.2"
+	if _, err := pc.CheckForUpdate(context.Background()); err == nil {
+		t.Fatal("Check with failing server succeeded")
+	}
+}
@@ -0,0 +1,170 @@
+package patchclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRetries is the number of retries of transient errors
+	// of clients created by the constructors.
+	DefaultRetries = 3
+	// DefaultRetryBackoff is the delay before the first retry, which
+	// is doubled for every further retry.
+	DefaultRetryBackoff = time.Second
+
+	maxRetryBackoff = time.Minute
+)
+
+// transientError is an error, which may not happen again, p.e. a
+// broken connection or status code 503.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// download is a GET request, which is retried with exponential backoff
+// on transient errors. If the connection breaks while reading the
+// body, the download is resumed with a range request. If-Range makes
+// sure that the resumed part belongs to the same artifact.
+type download struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	retries  int
+	backoff  time.Duration
+	progress ProgressFunc
+
+	body      io.ReadCloser
+	total     int64
+	validator string
+	offset    int64
+	attempt   int
+}
+
+// get returns the body of url and reports the download to progress,
+// if not nil. Caller has to close the io.ReadCloser.
+func (pc *PatchClient) get(ctx context.Context, url string, progress ProgressFunc) (io.ReadCloser, error) {
+	d := &download{
+		ctx:      ctx,
+		client:   pc.httpClient(),
+		url:      url,
+		retries:  pc.Retries,
+		backoff:  pc.RetryBackoff,
+		progress: progress,
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// open requests the url from offset and retries transient errors.
+func (d *download) open() error {
+	for {
+		err := d.request()
+		if _, ok := err.(*transientError); !ok || d.attempt >= d.retries {
+			return err
+		}
+		log.Printf("Retry download of %s: %v", d.url, err)
+		if err := d.wait(); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps the backoff of the current attempt.
+func (d *download) wait() error {
+	delay := d.backoff << uint(d.attempt)
+	if delay <= 0 || delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	d.attempt++
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (d *download) request() error {
+	req, err := http.NewRequest(http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	if d.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+		req.Header.Set("If-Range", d.validator)
+	}
+	resp, err := d.client.Do(req.WithContext(d.ctx))
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return d.ctx.Err()
+		}
+		return &transientError{err}
+	}
+
+	if warning := resp.Header.Get("Warning"); warning != "" && d.offset == 0 {
+		log.Printf("Warning: %s", warning)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		return errNotModified
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		resp.Body.Close()
+		return &transientError{fmt.Errorf("failed to get update with status code: %d", resp.StatusCode)}
+	case resp.StatusCode >= 400:
+		resp.Body.Close()
+		return fmt.Errorf("failed to get update with status code: %d", resp.StatusCode)
+	case d.offset > 0 && resp.StatusCode != http.StatusPartialContent:
+		resp.Body.Close()
+		return fmt.Errorf("failed to resume download of %s, it changed", d.url)
+	}
+
+	if d.offset == 0 {
+		d.total = resp.ContentLength
+		d.validator = resp.Header.Get("ETag")
+		if d.validator == "" {
+			d.validator = resp.Header.Get("Last-Modified")
+		}
+	}
+	d.body = resp.Body
+	return nil
+}
+
+// Read reads the body and resumes the download, if the connection
+// breaks and the server sent a validator to resume it safely.
+func (d *download) Read(p []byte) (int, error) {
+	n, err := d.body.Read(p)
+	d.offset += int64(n)
+	if d.progress != nil && n > 0 {
+		d.progress(PhaseDownload, d.offset, d.total)
+	}
+	if err == nil || err == io.EOF || d.ctx.Err() != nil || d.validator == "" || d.attempt >= d.retries {
+		return n, err
+	}
+	d.body.Close()
+	log.Printf("Resume download of %s at %d bytes: %v", d.url, d.offset, err)
+	if err := d.wait(); err != nil {
+		return n, err
+	}
+	if err := d.open(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (d *download) Close() error {
+	return d.body.Close()
+}
@@ -0,0 +1,63 @@
+package patchclient
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPatchClient_get(t *testing.T) {
+	content := []byte("0123456789")
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// connection breaks after half of the content
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", "10")
+			w.Write(content[:5])
+		default:
+			if r.Header.Get("Range") != "bytes=5-" || r.Header.Get("If-Range") != `"v1"` {
+				http.Error(w, "wrong range request", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer srv.Close()
+
+	pc := NewInsecurePatchClient(srv.URL, "v0.0.1")
+	pc.RetryBackoff = time.Millisecond
+	var received, total int64
+	rc, err := pc.get(context.Background(), srv.URL, func(phase string, n, size int64) {
+		received, total = n, size
+	})
+	if err != nil {
+		t.Fatalf("Failed to retry download: %v", err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(b, content) {
+		t.Fatalf("Failed to resume download %q: %v", b, err)
+	}
+	if requests != 3 {
+		t.Fatalf("Wrong number of requests %d", requests)
+	}
+	if received != 10 || total != 10 {
+		t.Fatalf("Wrong progress %d of %d bytes", received, total)
+	}
+
+	requests = 0
+	pc.Retries = 0
+	if _, err := pc.get(context.Background(), srv.URL, nil); err == nil {
+		t.Fatal("Download without retries succeeded")
+	}
+}
@@ -0,0 +1,132 @@
+package patchclient
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+var (
+	ErrUntrustedKey = errors.New("patchclient: update is not signed by a trusted key")
+	ErrExpiredKey   = errors.New("patchclient: public key expired")
+)
+
+// PublicKey is a public key trusted to sign updates.
+type PublicKey struct {
+	// ID identifies the key, the server sends the ID of the key,
+	// which signed an update.
+	ID string `json:"id"`
+	// PEM is the PEM encoded public key.
+	PEM string `json:"public-key"`
+	// NotAfter is the time after which the key is not trusted
+	// anymore. The zero value never expires.
+	NotAfter time.Time `json:"not-after,omitempty"`
+}
+
+func (k PublicKey) expired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && now.After(k.NotAfter)
+}
+
+// CrossSignedKey is a key sent by the server, which is trusted, if
+// the key SignedBy is trusted and CrossSignature is its signature of
+// the new key.
+type CrossSignedKey struct {
+	ID             string `json:"id"`
+	PublicKey      string `json:"public-key"`
+	SignedBy       string `json:"signed-by"`
+	CrossSignature []byte `json:"cross-signature"`
+}
+
+// Keyring contains all keys trusted to sign updates.
+type Keyring []PublicKey
+
+// LoadKeyring reads a keyring from a JSON file containing a list of
+// public keys, p.e.:
+//
+//	[{"id": "release-2017", "public-key": "-----BEGIN PUBLIC KEY-----\n...", "not-after": "2018-06-30T00:00:00Z"}]
+func LoadKeyring(fpath string) (Keyring, error) {
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var kr Keyring
+	if err := json.Unmarshal(b, &kr); err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+	}
+	return kr, nil
+}
+
+func (kr Keyring) get(id string) (PublicKey, bool) {
+	for _, k := range kr {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return PublicKey{}, false
+}
+
+// resolve returns the public key id. Keys, which are not in kr, are
+// trusted, if chain contains a cross-signature of the key by a
+// trusted key.
+func (kr Keyring) resolve(id string, chain []CrossSignedKey, now time.Time) (crypto.PublicKey, error) {
+	return kr.resolveChain(id, chain, now, make(map[string]bool))
+}
+
+func (kr Keyring) resolveChain(id string, chain []CrossSignedKey, now time.Time, seen map[string]bool) (crypto.PublicKey, error) {
+	if k, ok := kr.get(id); ok {
+		if k.expired(now) {
+			return nil, fmt.Errorf("%s: %s expired at %s", ErrExpiredKey, id, k.NotAfter)
+		}
+		return signature.ParsePublicKeyPEM([]byte(k.PEM))
+	}
+	if seen[id] {
+		return nil, fmt.Errorf("%s: cross-signature cycle at %s", ErrUntrustedKey, id)
+	}
+	seen[id] = true
+
+	for _, c := range chain {
+		if c.ID != id {
+			continue
+		}
+		signer, err := kr.resolveChain(c.SignedBy, chain, now, seen)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := signature.ParsePublicKeyPEM([]byte(c.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", id, err)
+		}
+		if err := signature.VerifyKey(signer, pub, c.CrossSignature); err != nil {
+			return nil, fmt.Errorf("%s: cross-signature of %s by %s: %v", ErrUntrustedKey, id, c.SignedBy, err)
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("%s: unknown key %s", ErrUntrustedKey, id)
+}
+
+// find returns the first key of kr, which is not expired and verifies
+// the signature of checksum.
+func (kr Keyring) find(checksum, sig []byte, now time.Time) (crypto.PublicKey, error) {
+	for _, k := range kr {
+		if k.expired(now) {
+			continue
+		}
+		pub, err := signature.ParsePublicKeyPEM([]byte(k.PEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", k.ID, err)
+		}
+		signatureType, err := signature.TypeOf(pub)
+		if err != nil {
+			return nil, err
+		}
+		if signature.Verify(signatureType, pub, checksum, sig) == nil {
+			return pub, nil
+		}
+	}
+	return nil, ErrUntrustedKey
+}
@@ -0,0 +1,105 @@
+package patchclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func crossSign(t *testing.T, signer, priv *ecdsa.PrivateKey) []byte {
+	digest, err := signature.KeyDigest(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, signer, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestKeyring_resolve(t *testing.T) {
+	now := time.Now()
+	oldKey, oldPEM := newTestKey(t)
+	newKey, newPEM := newTestKey(t)
+	nextKey, nextPEM := newTestKey(t)
+	_, expiredPEM := newTestKey(t)
+
+	kr := Keyring{
+		{ID: "old", PEM: oldPEM, NotAfter: now.Add(time.Hour)},
+		{ID: "expired", PEM: expiredPEM, NotAfter: now.Add(-time.Hour)},
+	}
+	chain := []CrossSignedKey{
+		{ID: "next", PublicKey: nextPEM, SignedBy: "new", CrossSignature: crossSign(t, newKey, nextKey)},
+		{ID: "new", PublicKey: newPEM, SignedBy: "old", CrossSignature: crossSign(t, oldKey, newKey)},
+	}
+
+	for _, tc := range []struct {
+		id    string
+		chain []CrossSignedKey
+		want  *ecdsa.PublicKey
+	}{
+		{"old", nil, &oldKey.PublicKey},
+		{"new", chain, &newKey.PublicKey},
+		{"next", chain, &nextKey.PublicKey},
+		{"new", nil, nil},
+		{"expired", nil, nil},
+		{"new", []CrossSignedKey{{ID: "new", PublicKey: newPEM, SignedBy: "old", CrossSignature: crossSign(t, newKey, newKey)}}, nil},
+		{"new", []CrossSignedKey{{ID: "new", PublicKey: newPEM, SignedBy: "new"}}, nil},
+	} {
+		pub, err := kr.resolve(tc.id, tc.chain, now)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("Resolved untrusted key %s", tc.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to resolve %s: %v", tc.id, err)
+			continue
+		}
+		if !tc.want.Equal(pub) {
+			t.Errorf("Wrong key resolved for %s", tc.id)
+		}
+	}
+}
+
+func TestKeyring_find(t *testing.T) {
+	now := time.Now()
+	oldKey, oldPEM := newTestKey(t)
+	newKey, newPEM := newTestKey(t)
+	kr := Keyring{
+		{ID: "old", PEM: oldPEM, NotAfter: now.Add(-time.Hour)},
+		{ID: "new", PEM: newPEM},
+	}
+	checksum := sha256.Sum256([]byte("binary"))
+
+	sig, _ := ecdsa.SignASN1(rand.Reader, newKey, checksum[:])
+	pub, err := kr.find(checksum[:], sig, now)
+	if err != nil || !newKey.PublicKey.Equal(pub) {
+		t.Fatalf("Failed to find new key: %v", err)
+	}
+	sig, _ = ecdsa.SignASN1(rand.Reader, oldKey, checksum[:])
+	if _, err := kr.find(checksum[:], sig, now); err == nil {
+		t.Fatal("Found expired key")
+	}
+}
@@ -0,0 +1,236 @@
+package patchclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/szuecs/binary-patch/semver"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+var (
+	ErrManifestSignature = errors.New("patchclient: invalid manifest signature")
+	ErrManifestExpired   = errors.New("patchclient: manifest expired")
+	ErrManifestMismatch  = errors.New("patchclient: update does not match the manifest")
+	ErrDowngrade         = errors.New("patchclient: refuse to downgrade")
+	ErrFrozen            = errors.New("patchclient: server withholds an update listed in the manifest")
+)
+
+// defaultChannels are the release channels of manifests, which do not
+// list the channels of the application, ordered from most to least
+// stable.
+var defaultChannels = []string{"stable", "beta", "nightly"}
+
+// Manifest lists all releases of an application signed by the
+// server. It is used to detect a server or mirror, which withholds
+// updates or serves outdated releases.
+type Manifest struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Expires   time.Time `json:"expires"`
+	// Channels are the release channels of the application ordered
+	// from most to least stable.
+	Channels []string          `json:"channels,omitempty"`
+	Releases []ManifestRelease `json:"releases"`
+}
+
+// ManifestRelease is one artifact listed in a Manifest.
+type ManifestRelease struct {
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	OS      string `json:"os"`
+	Channel string `json:"channel"`
+	Rollout int    `json:"rollout"`
+	// SHA256 is the hex encoded digest of the artifact
+	SHA256 string `json:"sha256"`
+	// RollbackFrom is set, if releases newer than this one up to
+	// RollbackFrom are rolled back to this release.
+	RollbackFrom string `json:"rollback-from,omitempty"`
+	// State is yanked, deprecated or paused, empty for active releases.
+	State string `json:"state,omitempty"`
+}
+
+// SignedManifest is a Manifest as sent by the server, Signature is
+// created over the SHA256 digest of Signed.
+type SignedManifest struct {
+	Signed        json.RawMessage `json:"signed"`
+	Signature     []byte          `json:"signature"`
+	SignatureType string          `json:"signature-type"`
+}
+
+// VerifyManifest verifies the signature of the JSON encoded
+// SignedManifest b with pub and returns the manifest, if it is not
+// expired at now.
+func VerifyManifest(b []byte, pub crypto.PublicKey, now time.Time) (*Manifest, error) {
+	var sm SignedManifest
+	if err := json.Unmarshal(b, &sm); err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+	}
+	keyType, err := signature.TypeOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	if sm.SignatureType != keyType {
+		return nil, fmt.Errorf("%s: signed by %s, but public key is %s", ErrManifestSignature, sm.SignatureType, keyType)
+	}
+	digest := sha256.Sum256(sm.Signed)
+	if err := signature.Verify(keyType, pub, digest[:], sm.Signature); err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrManifestSignature, err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(sm.Signed, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+	}
+	if now.After(m.Expires) {
+		return nil, fmt.Errorf("%s: at %s", ErrManifestExpired, m.Expires)
+	}
+	return &m, nil
+}
+
+// release returns the release of version for the running system.
+func (m *Manifest) release(version string) (ManifestRelease, bool) {
+	for _, r := range m.Releases {
+		if r.Version == version && r.Arch == runtime.GOARCH && r.OS == runtime.GOOS {
+			return r, true
+		}
+	}
+	return ManifestRelease{}, false
+}
+
+// rolledBack returns true, if version is replaced by a rollback on
+// the running system.
+func (m *Manifest) rolledBack(version string) bool {
+	for _, r := range m.Releases {
+		if r.RollbackFrom == "" || r.Arch != runtime.GOARCH || r.OS != runtime.GOOS {
+			continue
+		}
+		newer, err := semver.Compare(version, r.Version)
+		if err != nil {
+			continue
+		}
+		upTo, err := semver.Compare(version, r.RollbackFrom)
+		if err == nil && newer > 0 && upTo <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// channelRank returns the position of channel in the channels of m,
+// such that lower ranks are more stable, and false if channel is
+// unknown. The empty channel is the most stable channel.
+func (m *Manifest) channelRank(channel string) (int, bool) {
+	if channel == "" {
+		return 0, true
+	}
+	channels := m.Channels
+	if len(channels) == 0 {
+		channels = defaultChannels
+	}
+	for i, c := range channels {
+		if c == channel {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// offered returns true, if the server offers releases of
+// releaseChannel to clients of channel, which are releases of channel
+// and of more stable channels.
+func (m *Manifest) offered(releaseChannel, channel string) bool {
+	rank, ok := m.channelRank(channel)
+	if !ok {
+		return releaseChannel == channel
+	}
+	r, ok := m.channelRank(releaseChannel)
+	return ok && r <= rank
+}
+
+// latest returns the newest version offered to clients of channel for
+// the running system, which is rolled out to all clients, not yanked
+// or paused and not rolled back.
+func (m *Manifest) latest(channel string) string {
+	latest := ""
+	for _, r := range m.Releases {
+		if r.Arch != runtime.GOARCH || r.OS != runtime.GOOS || !m.offered(r.Channel, channel) || r.Rollout < 100 || r.State == StateYanked || r.State == StatePaused || m.rolledBack(r.Version) {
+			continue
+		}
+		if latest == "" {
+			latest = r.Version
+			continue
+		}
+		if c, err := semver.Compare(r.Version, latest); err == nil && c > 0 {
+			latest = r.Version
+		}
+	}
+	return latest
+}
+
+// getManifest fetches the manifest of the running binary from
+// ManifestURL and verifies it with ManifestPublicKey.
+func (pc *PatchClient) getManifest(ctx context.Context) (*Manifest, error) {
+	binary := GetLocalBinaryName()
+	rc, err := pc.get(ctx, fmt.Sprintf("%s/%s", pc.ManifestURL, binary), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %v", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrReadJSON, err)
+	}
+	pub, err := signature.ParsePublicKeyPEM(pc.ManifestPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest public key: %v", err)
+	}
+	m, err := VerifyManifest(b, pub, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if m.Name != binary {
+		return nil, fmt.Errorf("%s: manifest of %s, expected %s", ErrManifestMismatch, m.Name, binary)
+	}
+	return m, nil
+}
+
+// checkManifest returns an error, if the update data is not listed in
+// m or the server withholds a newer version.
+func (pc *PatchClient) checkManifest(m *Manifest, data SignedUpdate) error {
+	r, ok := m.release(data.Version)
+	if !ok {
+		return fmt.Errorf("%s: version %q is not listed", ErrManifestMismatch, data.Version)
+	}
+	if digest := strings.TrimSpace(string(data.Digest)); r.SHA256 != digest {
+		return &DigestMismatchError{Subject: "manifest of " + data.Version, Expected: r.SHA256, Actual: digest}
+	}
+	return pc.checkNotFrozen(m, data.Version)
+}
+
+// checkNotFrozen returns ErrFrozen, if the server offers version,
+// which is the running version if there is no update, but m lists a
+// newer version rolled out to all clients of the channel of pc.
+// Clients without channel get the most stable channel.
+func (pc *PatchClient) checkNotFrozen(m *Manifest, version string) error {
+	latest := m.latest(pc.Channel)
+	if latest == "" {
+		return nil
+	}
+	c, err := semver.Compare(latest, version)
+	if err != nil {
+		return err
+	}
+	if c > 0 {
+		return fmt.Errorf("%s: %s", ErrFrozen, latest)
+	}
+	return nil
+}
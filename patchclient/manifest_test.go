@@ -0,0 +1,103 @@
+package patchclient
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	key, _ := newTestKey(t)
+	other, _ := newTestKey(t)
+	now := time.Now()
+
+	sign := func(m Manifest) []byte {
+		b, _ := json.Marshal(m)
+		digest := sha256.Sum256(b)
+		sig, typ, err := signature.Sign(key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, _ := json.Marshal(SignedManifest{Signed: b, Signature: sig, SignatureType: typ})
+		return signed
+	}
+
+	valid := sign(Manifest{Name: "foo", Expires: now.Add(time.Hour)})
+	if _, err := VerifyManifest(valid, &key.PublicKey, now); err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+	if _, err := VerifyManifest(valid, &other.PublicKey, now); err == nil || !strings.Contains(err.Error(), ErrManifestSignature.Error()) {
+		t.Fatalf("Verified manifest with other key: %v", err)
+	}
+	expired := sign(Manifest{Name: "foo", Expires: now.Add(-time.Hour)})
+	if _, err := VerifyManifest(expired, &key.PublicKey, now); err == nil || !strings.Contains(err.Error(), ErrManifestExpired.Error()) {
+		t.Fatalf("Verified expired manifest: %v", err)
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("binary")))
+	release := func(version, channel string, rollout int) ManifestRelease {
+		return ManifestRelease{Version: version, Arch: runtime.GOARCH, OS: runtime.GOOS, Channel: channel, Rollout: rollout, SHA256: digest}
+	}
+	m := &Manifest{Name: "foo", Releases: []ManifestRelease{
+		release("v0.0.1", "stable", 100),
+		release("v0.0.2", "stable", 100),
+		release("v0.0.3", "stable", 100),
+		release("v0.0.4", "stable", 10),
+		release("v0.0.5", "beta", 100),
+	}}
+	pc := &PatchClient{Version: "v0.0.2", Channel: "stable"}
+
+	for _, tc := range []struct {
+		version string
+		digest  string
+		ok      bool
+	}{
+		{"v0.0.3", digest, true},
+		{"v0.0.4", digest, true},
+		{"v0.0.3", "other", false},
+		{"v0.0.6", digest, false},
+		{"v0.0.2", digest, false},
+		{"v0.0.1", digest, false},
+	} {
+		err := pc.checkManifest(m, SignedUpdate{Version: tc.version, Digest: []byte(tc.digest + "\n")})
+		if tc.ok && err != nil {
+			t.Errorf("Failed to check %s: %v", tc.version, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("Accepted %s with digest %s", tc.version, tc.digest)
+		}
+	}
+
+	if err := pc.checkNotFrozen(m, pc.Version); err == nil {
+		t.Error("Frozen update not detected")
+	}
+	pc.Version = "v0.0.3"
+	if err := pc.checkNotFrozen(m, pc.Version); err != nil {
+		t.Errorf("Latest version detected as frozen: %v", err)
+	}
+
+	// clients without channel get the most stable channel
+	pc.Channel = ""
+	if err := pc.checkNotFrozen(m, "v0.0.2"); err == nil {
+		t.Error("Frozen update of client without channel not detected")
+	}
+	if err := pc.checkNotFrozen(m, "v0.0.3"); err != nil {
+		t.Errorf("Beta release detected as frozen for stable client: %v", err)
+	}
+
+	// beta clients are offered stable releases, too
+	m.Channels = []string{"stable", "beta"}
+	m.Releases = m.Releases[:3]
+	pc.Channel = "beta"
+	if err := pc.checkNotFrozen(m, "v0.0.2"); err == nil {
+		t.Error("Frozen stable update of beta client not detected")
+	}
+}
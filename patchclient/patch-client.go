@@ -5,21 +5,28 @@ package patchclient
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"runtime"
 	"strings"
+	"time"
 
 	update "github.com/inconshreveable/go-update"
+	"github.com/szuecs/binary-patch/semver"
+	"github.com/szuecs/binary-patch/signature"
 )
 
 var (
@@ -27,128 +34,441 @@ var (
 	ErrApplyUpdate   = errors.New("patchclient: failed to apply update")
 	ErrUnmarshalJSON = errors.New("patchclient: failed to unmarshal json")
 	ErrReadJSON      = errors.New("patchclient: failed to read json")
+	ErrMissingDigest = errors.New("patchclient: missing digest in signed patch update")
+
+	errNotModified = errors.New("you already have the latest version")
 )
 
+// DigestMismatchError is returned, if the SHA256 digest of the
+// received patch or of the local binary to patch does not match the
+// digest expected by the server. The update is not applied.
+type DigestMismatchError struct {
+	// Subject is the checked data, p.e. "patch" or the path of the
+	// local binary.
+	Subject  string
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("patchclient: sha256 of %s is %s, expected %s", e.Subject, e.Actual, e.Expected)
+}
+
+// RolledBackError is returned, if a signed update failed and the
+// running binary was kept.
+type RolledBackError struct {
+	// Kind is the kind of the update, p.e. "update" or "patch update"
+	Kind string
+	Err  error
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("successfully rolled back signed %s: %v", e.Kind, e.Err)
+}
+
+func (e *RolledBackError) Unwrap() error {
+	return e.Err
+}
+
 type PatchClient struct {
-	URL       string
-	Version   string
+	URL     string
+	Version string
+	// PublicKey is the PEM encoded public key to verify signed
+	// updates, if Keyring is empty.
 	PublicKey []byte
+	// Keyring contains the keys trusted to verify signed updates. It
+	// allows to rotate keys, see Keyring.
+	Keyring Keyring
+	// Channel is the release channel to get updates from, p.e.
+	// stable, beta or nightly. If empty the server uses its most
+	// stable channel.
+	Channel string
+	// InstallID identifies this installation of the application. The
+	// server uses it to decide, if this installation is part of a
+	// staged rollout. Installations without ID only get releases
+	// rolled out to all clients.
+	InstallID string
+	// ManifestURL is the base URL of the signed release manifests,
+	// p.e. http://localhost:8080/manifest. If set, signed updates
+	// are only applied, if they are listed in a valid manifest. The
+	// manifest binds the version of an update to its digest, without
+	// it the version is not signed and downgrades are only detected,
+	// if the server is honest.
+	ManifestURL string
+	// ManifestPublicKey is the PEM encoded public key to verify
+	// manifests.
+	ManifestPublicKey []byte
+	// AllowDowngrade allows to install versions older than Version
+	// without signed rollback authorization. The version of an update
+	// is only signed by the manifest, see ManifestURL.
+	AllowDowngrade bool
+	// HTTPClient is used for all requests to the server, p.e. to set
+	// timeouts, CA certificates, client certificates or a proxy. If
+	// nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Retries is the number of retries of transient errors, p.e.
+	// broken connections. Broken downloads are resumed, if the
+	// server supports range requests.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which is
+	// doubled for every further retry.
+	RetryBackoff time.Duration
+	// Progress is called while an update is downloaded, verified and
+	// applied, p.e. to show a progress bar.
+	Progress ProgressFunc
+
+	// targetPath is the file replaced by updates, defaults to the
+	// running executable
+	targetPath string
 }
 
 // NewInsecurePatchClient is not able to verify the signature of your update.
 func NewInsecurePatchClient(url, version string) *PatchClient {
 	return &PatchClient{
-		URL:     url,
-		Version: version,
+		URL:          url,
+		Version:      version,
+		Retries:      DefaultRetries,
+		RetryBackoff: DefaultRetryBackoff,
 	}
 }
 
 // NewPatchClient is able to verify updates.
 func NewPatchClient(url, version string, pubKey []byte) *PatchClient {
 	return &PatchClient{
-		URL:       url,
-		Version:   version,
-		PublicKey: pubKey,
+		URL:          url,
+		Version:      version,
+		PublicKey:    pubKey,
+		Retries:      DefaultRetries,
+		RetryBackoff: DefaultRetryBackoff,
 	}
 }
 
-func (pc *PatchClient) UnsignedNotVerifiedUpdate() error {
-	rc, err := GetUpdate(pc.URL, pc.Version)
+// NewPatchClientWithKeyring is able to verify updates signed by any
+// trusted key of keyring.
+func NewPatchClientWithKeyring(url, version string, keyring Keyring) *PatchClient {
+	return &PatchClient{
+		URL:          url,
+		Version:      version,
+		Keyring:      keyring,
+		Retries:      DefaultRetries,
+		RetryBackoff: DefaultRetryBackoff,
+	}
+}
+
+// UnsignedNotVerifiedUpdate downloads and applies the full binary
+// without verification.
+func (pc *PatchClient) UnsignedNotVerifiedUpdate(ctx context.Context) error {
+	rc, err := pc.getUpdate(ctx)
 	if err != nil {
 		return fmt.Errorf("%s: %v", ErrGetUpdate, err)
 	}
 	if err := pc.ApplyUpdate(rc); err != nil {
-		return fmt.Errorf("%s: %v", ErrApplyUpdate, err)
+		return fmt.Errorf("%s: %w", ErrApplyUpdate, err)
 	}
 	return rc.Close()
 }
 
-func (pc *PatchClient) UnsignedNotVerifiedPatchUpdate() error {
-	rc, err := GetUpdate(pc.URL, pc.Version)
+// UnsignedNotVerifiedPatchUpdate downloads and applies the binary
+// patch without verification.
+func (pc *PatchClient) UnsignedNotVerifiedPatchUpdate(ctx context.Context) error {
+	rc, err := pc.getUpdate(ctx)
 	if err != nil {
 		return fmt.Errorf("%s: %v", ErrGetUpdate, err)
 	}
 	if err := pc.ApplyUpdateWithPatch(rc); err != nil {
-		return fmt.Errorf("%s: %v", ErrApplyUpdate, err)
+		return fmt.Errorf("%s: %w", ErrApplyUpdate, err)
 	}
 	return rc.Close()
 }
 
-func (pc *PatchClient) SignedVerifiedUpdate() error {
-	rc, err := GetUpdate(pc.URL, pc.Version)
+// SignedVerifiedUpdate downloads the full binary and applies it, if
+// the signature is valid.
+func (pc *PatchClient) SignedVerifiedUpdate(ctx context.Context) error {
+	data, err := pc.getSignedUpdate(ctx)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrGetUpdate, err)
+		return err
 	}
-	jsonUpdateData, err := ioutil.ReadAll(rc)
+
+	size := int64(len(data.Patch))
+	pc.progress(PhaseVerify, size, size)
+	checksum, pub, err := pc.signingKey(data)
+	if err != nil {
+		return err
+	}
+
+	pc.progress(PhaseApply, size, size)
+	buf := bytes.NewBuffer(data.Patch)
+	r := bufio.NewReader(buf)
+	rcPatch := ioutil.NopCloser(r)
+	err = pc.applyVerifiedUpdate(rcPatch, nil, checksum, data.Signature, pub)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrReadJSON, err)
+		return fmt.Errorf("%s: %w", ErrApplyUpdate, err)
 	}
-	err = rc.Close()
+	return rcPatch.Close()
+}
+
+// SignedVerifiedPatchUpdate downloads the binary patch and applies
+// it, if the signature of the patched binary is valid.
+func (pc *PatchClient) SignedVerifiedPatchUpdate(ctx context.Context) error {
+	data, err := pc.getSignedUpdate(ctx)
 	if err != nil {
 		return err
 	}
 
-	var data SignedUpdate
-	err = json.Unmarshal(jsonUpdateData, &data)
+	size := int64(len(data.Patch))
+	pc.progress(PhaseVerify, size, size)
+	if err = verifyPatch(data); err != nil {
+		return err
+	}
+	checksum, pub, err := pc.signingKey(data)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+		return err
 	}
 
+	pc.progress(PhaseApply, size, size)
 	buf := bytes.NewBuffer(data.Patch)
 	r := bufio.NewReader(buf)
 	rcPatch := ioutil.NopCloser(r)
-	sDigest := strings.TrimSpace(string(data.Digest))
-	sSign := hex.EncodeToString(data.Signature)
-	err = pc.ApplyVerifiedUpdate(rcPatch, sDigest, sSign)
+	err = pc.applyVerifiedUpdate(rcPatch, update.NewBSDiffPatcher(), checksum, data.Signature, pub)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrApplyUpdate, err)
+		return fmt.Errorf("%s: %w", ErrApplyUpdate, err)
 	}
 	return rcPatch.Close()
 }
 
-func (pc *PatchClient) SignedVerifiedPatchUpdate() error {
-	rc, err := GetUpdate(pc.URL, pc.Version)
-	if err != nil {
-		return fmt.Errorf("%s: %v", ErrGetUpdate, err)
+// getSignedUpdate fetches the signed update and checks it against the
+// manifest, if pc has a ManifestURL.
+func (pc *PatchClient) getSignedUpdate(ctx context.Context) (SignedUpdate, error) {
+	var data SignedUpdate
+	var manifest *Manifest
+	if pc.ManifestURL != "" {
+		m, err := pc.getManifest(ctx)
+		if err != nil {
+			return data, err
+		}
+		manifest = m
 	}
 
-	jsonUpdateData, err := ioutil.ReadAll(rc)
+	rc, err := pc.getUpdate(ctx)
+	if err == errNotModified && manifest != nil {
+		if ferr := pc.checkNotFrozen(manifest, pc.Version); ferr != nil {
+			return data, ferr
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrReadJSON, err)
+		return data, fmt.Errorf("%s: %v", ErrGetUpdate, err)
 	}
+	jsonUpdateData, err := ioutil.ReadAll(rc)
 	rc.Close()
-
-	var data SignedUpdate
-	err = json.Unmarshal(jsonUpdateData, &data)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
+		return data, fmt.Errorf("%s: %v", ErrReadJSON, err)
 	}
 
-	buf := bytes.NewBuffer(data.Patch)
-	r := bufio.NewReader(buf)
-	rcPatch := ioutil.NopCloser(r)
-
-	digest := strings.TrimSpace(string(data.Digest))
-	sSign := hex.EncodeToString(data.Signature)
-	err = pc.ApplyVerifiedPatchUpdate(rcPatch, digest, sSign)
+	err = json.Unmarshal(jsonUpdateData, &data)
 	if err != nil {
-		return fmt.Errorf("%s: %v", ErrApplyUpdate, err)
+		return data, fmt.Errorf("%s: %v", ErrUnmarshalJSON, err)
 	}
-	return rcPatch.Close()
+	if manifest != nil {
+		if err = pc.checkManifest(manifest, data); err != nil {
+			return data, err
+		}
+	}
+	if err = pc.checkDowngrade(data); err != nil {
+		return data, err
+	}
+	return data, nil
 }
 
 // SignedUpdate contains data required to validate and verify patch
-// the applied patch. If the Digest is not correct, the Patch should
-// not be applied, if the Signature can not be verified, the patch
-// will return an error and you can rollback the patch.
+// the applied patch. If the PatchDigest or FromDigest is not correct,
+// the Patch is not applied, if the Digest or Signature of the
+// resulting binary can not be verified, the patch will return an error
+// and you can rollback the patch.
 type SignedUpdate struct {
-	// Patch contains the binary diff of current to next version
+	// Patch contains the binary diff of current to next version or
+	// the next version binary for signed full updates
 	Patch []byte `json:"patch"`
 	// Signature contains the signature of the next version binary
 	// to verify, if the resulting binary patch is correct.
 	Signature []byte `json:"signature"`
-	// Digest is the SHA256 of the Patch
+	// Digest is the hex encoded SHA256 of the next version binary
 	Digest []byte `json:"sha256"`
+	// PatchDigest is the hex encoded SHA256 of the Patch
+	PatchDigest string `json:"patch-sha256,omitempty"`
+	// FromDigest is the hex encoded SHA256 of the binary the Patch
+	// has to be applied to
+	FromDigest string `json:"from-sha256,omitempty"`
+	// ToDigest is the hex encoded SHA256 of the next version binary
+	ToDigest string `json:"to-sha256,omitempty"`
+	// SignatureType is the type of the Signature, p.e. ecdsa, ed25519
+	// or rsa-pss
+	SignatureType string `json:"signature-type,omitempty"`
+	// Version is the version of the next version binary
+	Version string `json:"version,omitempty"`
+	// KeyID is the ID of the key, which created the Signature
+	KeyID string `json:"key-id,omitempty"`
+	// KeyChain contains the key KeyID and the keys which
+	// cross-signed it, if the key is signed by an older key
+	KeyChain []CrossSignedKey `json:"key-chain,omitempty"`
+	// Rollback authorizes clients to downgrade to Version
+	Rollback *Rollback `json:"rollback,omitempty"`
+}
+
+// Rollback is a signed authorization to downgrade clients running a
+// version up to From to version To.
+type Rollback struct {
+	From          string           `json:"from"`
+	To            string           `json:"to"`
+	Signature     []byte           `json:"signature"`
+	SignatureType string           `json:"signature-type"`
+	KeyID         string           `json:"key-id,omitempty"`
+	KeyChain      []CrossSignedKey `json:"key-chain,omitempty"`
+}
+
+// signingKey returns the decoded checksum of data and the trusted
+// public key, which signed it.
+func (pc *PatchClient) signingKey(data SignedUpdate) ([]byte, crypto.PublicKey, error) {
+	checksum, err := hex.DecodeString(strings.TrimSpace(string(data.Digest)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode checksum: %v", err)
+	}
+	pub, err := pc.trustedKey(data.KeyID, data.KeyChain, checksum, data.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = checkSignatureType(pub, data.SignatureType); err != nil {
+		return nil, nil, err
+	}
+	return checksum, pub, nil
+}
+
+// trustedKey returns the trusted key keyID, or if the server did not
+// send the key ID, the key which verifies the signature sig of
+// checksum.
+func (pc *PatchClient) trustedKey(keyID string, chain []CrossSignedKey, checksum, sig []byte) (crypto.PublicKey, error) {
+	if len(pc.Keyring) > 0 && keyID != "" {
+		return pc.Keyring.resolve(keyID, chain, time.Now())
+	}
+	return pc.publicKey(checksum, sig)
+}
+
+// checkDowngrade returns ErrDowngrade, if data is not newer than the
+// running version, unless pc allows downgrades or data contains a
+// rollback authorization for the running version and the binary of
+// data signed by a trusted key. Updates without version are refused,
+// because their version can not be checked. The version of data is
+// only verified, if it was checked against the manifest before.
+func (pc *PatchClient) checkDowngrade(data SignedUpdate) error {
+	if pc.AllowDowngrade {
+		return nil
+	}
+	if data.Version == "" {
+		return fmt.Errorf("%w: missing version of update", ErrDowngrade)
+	}
+	c, err := semver.Compare(data.Version, pc.Version)
+	if err != nil {
+		return err
+	}
+	if c > 0 {
+		return nil
+	}
+	rb := data.Rollback
+	if rb == nil || rb.To != data.Version {
+		return fmt.Errorf("%w: from %s to %s", ErrDowngrade, pc.Version, data.Version)
+	}
+	if c, err := semver.Compare(pc.Version, rb.From); err != nil || c > 0 {
+		return fmt.Errorf("%w: rollback to %s is authorized up to %s, running %s", ErrDowngrade, rb.To, rb.From, pc.Version)
+	}
+
+	toSHA256 := strings.ToLower(strings.TrimSpace(string(data.Digest)))
+	digest := signature.RollbackDigest(GetLocalBinaryName(), rb.From, rb.To, toSHA256)
+	pub, err := pc.trustedKey(rb.KeyID, rb.KeyChain, digest, rb.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDowngrade, err)
+	}
+	if err = checkSignatureType(pub, rb.SignatureType); err != nil {
+		return err
+	}
+	if err = signature.Verify(rb.SignatureType, pub, digest, rb.Signature); err != nil {
+		return fmt.Errorf("%w: invalid rollback signature: %v", ErrDowngrade, err)
+	}
+	return nil
+}
+
+// publicKey returns the public key of pc or the key of the keyring,
+// which verifies the signature sig of checksum.
+func (pc *PatchClient) publicKey(checksum, sig []byte) (crypto.PublicKey, error) {
+	if len(pc.Keyring) > 0 {
+		return pc.Keyring.find(checksum, sig, time.Now())
+	}
+	return signature.ParsePublicKeyPEM(pc.PublicKey)
+}
+
+// checkSignatureType returns an error, if the signature type sent by
+// the server does not match the type of the public key.
+func checkSignatureType(pub crypto.PublicKey, signatureType string) error {
+	if signatureType == "" {
+		return nil
+	}
+	keyType, err := signature.TypeOf(pub)
+	if err != nil {
+		return err
+	}
+	if keyType != signatureType {
+		return fmt.Errorf("patchclient: update is signed by %s, but public key is %s", signatureType, keyType)
+	}
+	return nil
+}
+
+// setVerifier sets the public key and the Verifier matching the key
+// type in opts.
+func setVerifier(opts *update.Options, pub crypto.PublicKey) error {
+	signatureType, err := signature.TypeOf(pub)
+	if err != nil {
+		return err
+	}
+	verifier, err := signature.NewVerifier(signatureType)
+	if err != nil {
+		return err
+	}
+	opts.PublicKey = pub
+	opts.Verifier = verifier
+	return nil
+}
+
+// verifyPatch checks the digest of the received patch and that the
+// running binary is the one the patch was created for.
+func verifyPatch(data SignedUpdate) error {
+	if data.PatchDigest == "" || data.FromDigest == "" {
+		return ErrMissingDigest
+	}
+	if actual := fmt.Sprintf("%x", sha256.Sum256(data.Patch)); actual != data.PatchDigest {
+		return &DigestMismatchError{Subject: "patch", Expected: data.PatchDigest, Actual: actual}
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+	return verifyFileDigest(binary, data.FromDigest)
+}
+
+// verifyFileDigest returns a *DigestMismatchError, if the SHA256 of the
+// file fpath is not the hex encoded expected digest.
+func verifyFileDigest(fpath, expected string) error {
+	fd, err := os.Open(fpath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", fpath, err)
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return fmt.Errorf("failed to read %s: %v", fpath, err)
+	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != expected {
+		return &DigestMismatchError{Subject: fpath, Expected: expected, Actual: actual}
+	}
+	return nil
 }
 
 func GetLocalBinaryName() string {
@@ -161,7 +481,57 @@ func GetLocalBinaryName() string {
 func GetUpdate(baseUpdateURL, version string) (io.ReadCloser, error) {
 	binary := GetLocalBinaryName()
 	updateURL := getUpdateURL(baseUpdateURL, binary, version)
-	rc, err := getUpdate(updateURL.String())
+	pc := &PatchClient{Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff}
+	rc, err := pc.get(context.Background(), updateURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to getUpdate: %v", err)
+	}
+	return rc, nil
+}
+
+// updateURL returns the URL of the running binary below
+// baseUpdateURL with the version, channel and install ID of pc.
+func (pc *PatchClient) updateURL(baseUpdateURL string) *url.URL {
+	updateURL := getUpdateURL(baseUpdateURL, GetLocalBinaryName(), pc.Version)
+	query := updateURL.Query()
+	if pc.Channel != "" {
+		query.Set("channel", pc.Channel)
+	}
+	if pc.InstallID != "" {
+		query.Set("install_id", pc.InstallID)
+	}
+	updateURL.RawQuery = query.Encode()
+	return updateURL
+}
+
+// endpointURL returns the URL of the server endpoint, which is a
+// sibling of the endpoint of URL, p.e. http://localhost:8080/check
+// for http://localhost:8080/update.
+func (pc *PatchClient) endpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(pc.URL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(path.Dir(strings.TrimSuffix(u.Path, "/")), endpoint)
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// httpClient returns the HTTPClient of pc or http.DefaultClient.
+func (pc *PatchClient) httpClient() *http.Client {
+	if pc.HTTPClient != nil {
+		return pc.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// getUpdate is GetUpdate with the channel, install ID, HTTP client and
+// retries of pc.
+func (pc *PatchClient) getUpdate(ctx context.Context) (io.ReadCloser, error) {
+	rc, err := pc.get(ctx, pc.updateURL(pc.URL).String(), pc.Progress)
+	if err == errNotModified {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to getUpdate: %v", err)
 	}
@@ -204,38 +574,15 @@ func (pc *PatchClient) ApplyUpdateWithPatch(patch io.Reader) error {
 
 // ApplyVerifiedUpdate applies a signed binary update and checks the checksum.
 func (pc *PatchClient) ApplyVerifiedUpdate(binary io.ReadCloser, hexChecksum, hexSignature string) error {
-	defer binary.Close()
-	checksum, err := hex.DecodeString(hexChecksum)
-	if err != nil {
-		return fmt.Errorf("failed checksum: %v", err)
-	}
-	signature, err := hex.DecodeString(hexSignature)
-	if err != nil {
-		return fmt.Errorf("failed signature: %v", err)
-	}
-	opts := update.Options{
-		Checksum:  checksum,
-		Signature: signature,
-		Hash:      crypto.SHA256,
-		Verifier:  update.NewECDSAVerifier(),
-	}
-	err = opts.SetPublicKeyPEM(pc.PublicKey)
-	if err != nil {
-		return fmt.Errorf("failed set opts: %v", err)
-	}
-	err = update.Apply(binary, opts)
-	if err != nil {
-		if rerr := update.RollbackError(err); rerr != nil {
-			return fmt.Errorf("failed to rollback from bad signed update: %v", rerr)
-		}
-		log.Println("Rolled back signed patch Update")
-		return fmt.Errorf("successfully rolled back signed update with options %v: %v", opts, err)
-	}
-	return nil
+	return pc.applyHexVerifiedUpdate(binary, nil, hexChecksum, hexSignature)
 }
 
 // ApplyVerifiedPatchUpdate applies a signed binary patch and checks the checksum.
 func (pc *PatchClient) ApplyVerifiedPatchUpdate(binary io.ReadCloser, hexChecksum, hexSignature string) error {
+	return pc.applyHexVerifiedUpdate(binary, update.NewBSDiffPatcher(), hexChecksum, hexSignature)
+}
+
+func (pc *PatchClient) applyHexVerifiedUpdate(binary io.ReadCloser, patcher update.Patcher, hexChecksum, hexSignature string) error {
 	defer binary.Close()
 	checksum, err := hex.DecodeString(hexChecksum)
 	if err != nil {
@@ -245,51 +592,94 @@ func (pc *PatchClient) ApplyVerifiedPatchUpdate(binary io.ReadCloser, hexChecksu
 	if err != nil {
 		return fmt.Errorf("failed to decode signature: %v", err)
 	}
+	pub, err := pc.publicKey(checksum, signature)
+	if err != nil {
+		return err
+	}
+	return pc.applyVerifiedUpdate(binary, patcher, checksum, signature, pub)
+}
+
+// applyVerifiedUpdate applies a signed binary update, or binary patch
+// if patcher is not nil, and verifies the result with pub. A result,
+// which does not match checksum, is returned as *DigestMismatchError.
+func (pc *PatchClient) applyVerifiedUpdate(binary io.ReadCloser, patcher update.Patcher, checksum, signature []byte, pub crypto.PublicKey) error {
+	defer binary.Close()
+	kind := "update"
+	var updated *digestPatcher
+	var r io.Reader = binary
+	if patcher != nil {
+		kind = "patch update"
+		updated = &digestPatcher{Patcher: patcher, h: sha256.New()}
+		patcher = updated
+	} else {
+		b, err := ioutil.ReadAll(binary)
+		if err != nil {
+			return fmt.Errorf("failed to read update: %v", err)
+		}
+		if err = checkDigest(kind, b, checksum); err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
 	opts := update.Options{
-		Patcher:   update.NewBSDiffPatcher(),
-		Checksum:  checksum,
-		Signature: signature,
-		Hash:      crypto.SHA256,
-		Verifier:  update.NewECDSAVerifier(),
+		TargetPath: pc.targetPath,
+		Patcher:    patcher,
+		Checksum:   checksum,
+		Signature:  signature,
+		Hash:       crypto.SHA256,
 	}
-	err = opts.SetPublicKeyPEM(pc.PublicKey)
+	err := setVerifier(&opts, pub)
 	if err != nil {
 		return fmt.Errorf("failed set opts: %v", err)
 	}
-	err = update.Apply(binary, opts)
+	err = update.Apply(r, opts)
 	if err != nil {
 		if rerr := update.RollbackError(err); rerr != nil {
-			return fmt.Errorf("failed to rollback from bad signed patch update: %v", rerr)
+			return fmt.Errorf("failed to rollback from bad signed %s: %v", kind, rerr)
+		}
+		log.Printf("Rolled back signed %s", kind)
+		if updated != nil && updated.done {
+			if sum := updated.h.Sum(nil); !bytes.Equal(sum, checksum) {
+				err = &DigestMismatchError{Subject: "patched binary", Expected: hex.EncodeToString(checksum), Actual: hex.EncodeToString(sum)}
+			}
 		}
-		log.Println("Rolled back signed patch Update")
-		return fmt.Errorf("successfully rolled back signed patch update with options %v: %v", opts, err)
+		return &RolledBackError{Kind: kind, Err: err}
 	}
 	return nil
 }
 
-func getUpdateURL(baseUpdateURL, binary, version string) *url.URL {
-	updateURL, err := url.Parse(fmt.Sprintf("%s/%s?version=%s&arch=%s&os=%s", baseUpdateURL, binary, version, runtime.GOARCH, runtime.GOOS))
-	if err != nil {
-		log.Fatalf("Could not parse URL, caused by: %v", err)
+// checkDigest returns a *DigestMismatchError, if the SHA256 of b is
+// not checksum.
+func checkDigest(subject string, b, checksum []byte) error {
+	if sum := sha256.Sum256(b); !bytes.Equal(sum[:], checksum) {
+		return &DigestMismatchError{Subject: subject, Expected: hex.EncodeToString(checksum), Actual: hex.EncodeToString(sum[:])}
 	}
-	return updateURL
+	return nil
 }
 
-// getUpdate returns an open io.ReadCloser, if error is not
-// nil. Caller has to close the io.ReadCloser.
-func getUpdate(url string) (io.ReadCloser, error) {
-	// request new file
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
+// digestPatcher is an update.Patcher, which computes the SHA256 of the
+// patched binary.
+type digestPatcher struct {
+	update.Patcher
+	h    hash.Hash
+	done bool
+}
 
-	if resp.StatusCode >= 400 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("failed to get update with status code: %d", resp.StatusCode)
-	} else if resp.StatusCode == http.StatusNotModified {
-		resp.Body.Close()
-		return nil, fmt.Errorf("you already have the latest version")
+func (p *digestPatcher) Patch(old io.Reader, w io.Writer, patch io.Reader) error {
+	err := p.Patcher.Patch(old, io.MultiWriter(w, p.h), patch)
+	p.done = err == nil
+	return err
+}
+
+func getUpdateURL(baseUpdateURL, binary, version string) *url.URL {
+	updateURL, err := url.Parse(fmt.Sprintf("%s/%s", baseUpdateURL, binary))
+	if err != nil {
+		log.Fatalf("Could not parse URL, caused by: %v", err)
 	}
-	return resp.Body, nil
+	query := url.Values{}
+	query.Set("version", version)
+	query.Set("arch", runtime.GOARCH)
+	query.Set("os", runtime.GOOS)
+	updateURL.RawQuery = query.Encode()
+	return updateURL
 }
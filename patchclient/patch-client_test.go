@@ -0,0 +1,134 @@
+package patchclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestVerifyPatch(t *testing.T) {
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := []byte("patch")
+	data := SignedUpdate{
+		Patch:       patch,
+		PatchDigest: fmt.Sprintf("%x", sha256.Sum256(patch)),
+		FromDigest:  fmt.Sprintf("%x", sha256.Sum256(b)),
+	}
+	if err := verifyPatch(data); err != nil {
+		t.Fatalf("Failed to verify patch: %v", err)
+	}
+
+	corrupted := data
+	corrupted.Patch = []byte("corrupted")
+	if _, ok := verifyPatch(corrupted).(*DigestMismatchError); !ok {
+		t.Fatal("Corrupted patch not detected")
+	}
+
+	otherBinary := data
+	otherBinary.FromDigest = fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+	if err, ok := verifyPatch(otherBinary).(*DigestMismatchError); !ok || err.Subject != binary {
+		t.Fatalf("Patch for other binary not detected: %v", err)
+	}
+
+	missing := data
+	missing.PatchDigest = ""
+	if err := verifyPatch(missing); err != ErrMissingDigest {
+		t.Fatalf("Missing digest not detected: %v", err)
+	}
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	key, keyPEM := newTestKey(t)
+	other, _ := newTestKey(t)
+	name := GetLocalBinaryName()
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("binary v0.0.2")))
+	otherDigest := fmt.Sprintf("%x", sha256.Sum256([]byte("other binary v0.0.2")))
+	rollback := func(signer *ecdsa.PrivateKey, from, to, toSHA256 string) *Rollback {
+		sig, err := ecdsa.SignASN1(rand.Reader, signer, signature.RollbackDigest(name, from, to, toSHA256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Rollback{From: from, To: to, Signature: sig, SignatureType: signature.ECDSA, KeyID: "release"}
+	}
+	downgrade := func(version string, rb *Rollback) SignedUpdate {
+		return SignedUpdate{Version: version, Digest: []byte(digest + "\n"), Rollback: rb}
+	}
+	pc := &PatchClient{Version: "v0.0.3", Keyring: Keyring{{ID: "release", PEM: keyPEM}}}
+
+	for _, tc := range []struct {
+		data SignedUpdate
+		ok   bool
+	}{
+		{SignedUpdate{Version: "v0.0.4"}, true},
+		{SignedUpdate{}, false},
+		{SignedUpdate{Version: "v0.0.3"}, false},
+		{SignedUpdate{Version: "v0.0.2"}, false},
+		{downgrade("v0.0.2", rollback(key, "v0.0.3", "v0.0.2", digest)), true},
+		{downgrade("v0.0.2", rollback(key, "v0.0.4", "v0.0.2", digest)), true},
+		{downgrade("v0.0.2", rollback(key, "v0.0.3", "v0.0.2", otherDigest)), false},
+		{downgrade("v0.0.2", rollback(key, "v0.0.2", "v0.0.1", digest)), false},
+		{downgrade("v0.0.1", rollback(key, "v0.0.3", "v0.0.2", digest)), false},
+		{downgrade("v0.0.2", rollback(other, "v0.0.3", "v0.0.2", digest)), false},
+	} {
+		err := pc.checkDowngrade(tc.data)
+		if tc.ok && err != nil {
+			t.Errorf("Failed to update to %q: %v", tc.data.Version, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("Downgrade to %q not refused", tc.data.Version)
+		}
+	}
+
+	pc.AllowDowngrade = true
+	if err := pc.checkDowngrade(SignedUpdate{Version: "v0.0.2"}); err != nil {
+		t.Fatalf("Allowed downgrade refused: %v", err)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPatchClient_HTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	pc := NewPatchClient(srv.URL+"/signed-update", "v0.0.1", nil)
+	pc.HTTPClient = &http.Client{Transport: transport}
+	if err := pc.SignedVerifiedUpdate(context.Background()); err == nil {
+		t.Fatal("Update without newer version succeeded")
+	}
+	if transport.requests != 1 {
+		t.Fatalf("HTTPClient was used for %d requests, expected 1", transport.requests)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pc.SignedVerifiedUpdate(ctx); err == nil {
+		t.Fatal("Update with canceled context succeeded")
+	}
+}
@@ -0,0 +1,25 @@
+package patchclient
+
+// Phases of an update reported to PatchClient.Progress
+const (
+	// PhaseDownload is reported while the update is received.
+	PhaseDownload = "download"
+	// PhaseVerify is reported once, before digests and signatures
+	// of a signed update are verified.
+	PhaseVerify = "verify"
+	// PhaseApply is reported once, before a signed update replaces
+	// the binary. Unsigned updates are applied while downloading.
+	PhaseApply = "apply"
+)
+
+// ProgressFunc is called with the number of bytes received and the
+// total size of the update. total is -1, if the server did not send
+// the size.
+type ProgressFunc func(phase string, received, total int64)
+
+// progress calls the Progress callback of pc, if set.
+func (pc *PatchClient) progress(phase string, received, total int64) {
+	if pc.Progress != nil {
+		pc.Progress(phase, received, total)
+	}
+}
@@ -0,0 +1,88 @@
+package patchclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// Outcomes of an update reported by Report
+const (
+	OutcomeSuccess         = "success"
+	OutcomeChecksumFailure = "checksum-failure"
+	OutcomeRollback        = "rollback"
+	OutcomeFailure         = "failure"
+)
+
+// Report is the outcome of an update sent to the report endpoint of
+// the server.
+type Report struct {
+	InstallID     string `json:"install-id,omitempty"`
+	Version       string `json:"version"`
+	TargetVersion string `json:"target-version,omitempty"`
+	Architecture  string `json:"arch,omitempty"`
+	OS            string `json:"os,omitempty"`
+	Outcome       string `json:"outcome"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Report sends the outcome of the update to targetVersion to the
+// server, such that broken releases can be detected. updateErr is the
+// error returned by the update, nil if it succeeded. Version has to be
+// the version running after the update. The report endpoint is the
+// sibling of URL, p.e. http://localhost:8080/report for
+// http://localhost:8080/update.
+func (pc *PatchClient) Report(ctx context.Context, targetVersion string, updateErr error) error {
+	reportURL, err := pc.endpointURL("report")
+	if err != nil {
+		return fmt.Errorf("failed to create report URL: %v", err)
+	}
+	report := Report{
+		InstallID:     pc.InstallID,
+		Version:       pc.Version,
+		TargetVersion: targetVersion,
+		Architecture:  runtime.GOARCH,
+		OS:            runtime.GOOS,
+		Outcome:       outcomeOf(updateErr),
+	}
+	if updateErr != nil {
+		report.Error = updateErr.Error()
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, reportURL+"/"+GetLocalBinaryName(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := pc.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to report update with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// outcomeOf returns the outcome of an update, which returned err.
+func outcomeOf(err error) string {
+	var mismatch *DigestMismatchError
+	var rolledBack *RolledBackError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &mismatch):
+		return OutcomeChecksumFailure
+	case errors.As(err, &rolledBack):
+		return OutcomeRollback
+	}
+	return OutcomeFailure
+}
@@ -0,0 +1,115 @@
+package patchclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	update "github.com/inconshreveable/go-update"
+	"github.com/kr/binarydist"
+	"github.com/szuecs/binary-patch/signature"
+)
+
+func TestPatchClient_Report(t *testing.T) {
+	var got Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/report/"+GetLocalBinaryName() {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte(`{"message": "ok"}`))
+	}))
+	defer srv.Close()
+
+	pc := NewInsecurePatchClient(srv.URL+"/update", "v0.0.1")
+	pc.InstallID = "a"
+	updateErr := fmt.Errorf("%s: %w", ErrApplyUpdate, &RolledBackError{Kind: "update", Err: errors.New("boom")})
+	if err := pc.Report(context.Background(), "v0.0.2", updateErr); err != nil {
+		t.Fatalf("Failed to report: %v", err)
+	}
+	if got.InstallID != "a" || got.Version != "v0.0.1" || got.TargetVersion != "v0.0.2" || got.Outcome != OutcomeRollback || got.Error != updateErr.Error() {
+		t.Fatalf("Wrong report: %+v", got)
+	}
+
+	pc.URL = srv.URL + "/foo/update"
+	if err := pc.Report(context.Background(), "v0.0.2", nil); err == nil {
+		t.Fatal("Report to failing server succeeded")
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, OutcomeSuccess},
+		{&DigestMismatchError{Subject: "patch"}, OutcomeChecksumFailure},
+		{fmt.Errorf("%s: %w", ErrApplyUpdate, &RolledBackError{Kind: "patch update", Err: &DigestMismatchError{Subject: "patched binary"}}), OutcomeChecksumFailure},
+		{fmt.Errorf("%s: %w", ErrApplyUpdate, &RolledBackError{Kind: "update", Err: errors.New("boom")}), OutcomeRollback},
+		{errors.New("rolled back by checksum error in sha256"), OutcomeFailure},
+		{ErrUntrustedKey, OutcomeFailure},
+	} {
+		if got := outcomeOf(tc.err); got != tc.want {
+			t.Errorf("Wrong outcome of %v: %s, expected %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestApplyVerifiedUpdate_digestMismatch(t *testing.T) {
+	priv, pubPEM := newTestKey(t)
+	pub, err := signature.ParsePublicKeyPEM([]byte(pubPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "patchclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(target, []byte("binary v0.0.1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	pc := &PatchClient{targetPath: target}
+
+	expected := sha256.Sum256([]byte("binary v0.0.2"))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, expected[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch bytes.Buffer
+	if err := binarydist.Diff(strings.NewReader("binary v0.0.1"), strings.NewReader("tampered v0.0.2"), &patch); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		data    []byte
+		patcher update.Patcher
+	}{
+		{[]byte("tampered v0.0.2"), nil},
+		{patch.Bytes(), update.NewBSDiffPatcher()},
+	} {
+		err := pc.applyVerifiedUpdate(ioutil.NopCloser(bytes.NewReader(tc.data)), tc.patcher, expected[:], sig, pub)
+		if got := outcomeOf(err); got != OutcomeChecksumFailure {
+			t.Errorf("Wrong outcome %s of %v", got, err)
+		}
+	}
+	if b, err := ioutil.ReadFile(target); err != nil || string(b) != "binary v0.0.1" {
+		t.Fatalf("Binary changed to %q: %v", b, err)
+	}
+}
@@ -0,0 +1,30 @@
+package patchclient
+
+import (
+	"fmt"
+	"os"
+)
+
+// executable is the path of the running binary. It is resolved on
+// start, because after an update was applied the path of the running
+// process points to the replaced binary on some systems.
+var executable, errExecutable = os.Executable()
+
+// Restart replaces the running process by the updated binary with the
+// same arguments and environment. Call it after an update was applied
+// successfully. On success Restart does not return.
+func Restart() error {
+	return RestartWith(os.Args)
+}
+
+// RestartWith replaces the running process by the updated binary with
+// args and the same environment. args[0] is the program name.
+func RestartWith(args []string) error {
+	if errExecutable != nil {
+		return fmt.Errorf("failed to find executable: %v", errExecutable)
+	}
+	if len(args) == 0 {
+		args = []string{executable}
+	}
+	return restart(executable, args, os.Environ())
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package patchclient
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// restart execs binary in place of the running process.
+func restart(binary string, args, env []string) error {
+	if err := syscall.Exec(binary, args, env); err != nil {
+		return fmt.Errorf("failed to exec %s: %v", binary, err)
+	}
+	return nil
+}
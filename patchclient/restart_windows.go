@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package patchclient
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// restart starts binary and exits the running process, because
+// Windows does not support to exec in place of the running process.
+func restart(binary string, args, env []string) error {
+	cmd := exec.Command(binary, args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", binary, err)
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,59 @@
+package patchclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Release states, which can be set with SetReleaseState
+const (
+	// StateYanked releases are never offered as update, but clients
+	// running them can still be patched.
+	StateYanked = "yanked"
+	// StateDeprecated releases are offered, but clients running them
+	// get a warning.
+	StateDeprecated = "deprecated"
+	// StatePaused releases are not offered as update. The server
+	// pauses releases automatically, if too many clients report
+	// failed updates.
+	StatePaused = "paused"
+	// StateActive reverts a yanked, deprecated or paused release.
+	StateActive = "active"
+)
+
+// SetReleaseState changes the state of version of the application
+// name by calling the yank endpoint baseURL, p.e.
+// http://localhost:8080/yank. A non-empty token is sent as OAuth2
+// bearer token. The request is sent with client, if it is nil with
+// http.DefaultClient.
+func SetReleaseState(ctx context.Context, client *http.Client, baseURL, name, version, state, token string) error {
+	body, err := json.Marshal(map[string]string{"version": version, "state": state})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", baseURL, name), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to mark %s %s as %s with status code %d: %s", name, version, state, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package patchclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetReleaseState(t *testing.T) {
+	var data map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/yank/foo" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "wrong request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&data)
+		w.Write([]byte(`{"message": "ok"}`))
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport}
+	if err := SetReleaseState(context.Background(), client, srv.URL+"/yank", "foo", "v0.0.1", StateYanked, "token"); err != nil {
+		t.Fatalf("Failed to set release state: %v", err)
+	}
+	if data["version"] != "v0.0.1" || data["state"] != StateYanked || transport.requests != 1 {
+		t.Fatalf("Wrong request %v with %d requests by the client", data, transport.requests)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SetReleaseState(ctx, client, srv.URL+"/yank", "foo", "v0.0.1", StateActive, "token"); err == nil {
+		t.Fatal("Canceled request not aborted")
+	}
+}
@@ -0,0 +1,158 @@
+// Package semver parses and compares semantic versions as specified
+// by https://semver.org/spec/v2.0.0.html, which are used as release
+// versions by server and client.
+package semver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalid = errors.New("Invalid semantic version: ")
+
+// Version is a parsed semantic version. Build metadata is dropped,
+// because it does not take part in version precedence.
+type Version struct {
+	major, minor, patch uint64
+	pre                 []string
+}
+
+// Parse parses s as semantic version with an optional leading "v",
+// p.e. v1.2.3-rc.1+build.5
+func Parse(s string) (*Version, error) {
+	v := strings.TrimPrefix(s, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		if !validIdentifiers(v[i+1:], false) {
+			return nil, errors.Wrap(ErrInvalid, s)
+		}
+		v = v[:i]
+	}
+	var pre []string
+	if i := strings.Index(v, "-"); i >= 0 {
+		if !validIdentifiers(v[i+1:], true) {
+			return nil, errors.Wrap(ErrInvalid, s)
+		}
+		pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nil, errors.Wrap(ErrInvalid, s)
+	}
+	var nums [3]uint64
+	for i, p := range parts {
+		if !isNumeric(p) || (len(p) > 1 && p[0] == '0') {
+			return nil, errors.Wrap(ErrInvalid, s)
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalid, s)
+		}
+		nums[i] = n
+	}
+	return &Version{major: nums[0], minor: nums[1], patch: nums[2], pre: pre}, nil
+}
+
+// validIdentifiers checks the dot separated identifiers of a
+// pre-release or build metadata.
+func validIdentifiers(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher
+// precedence than o.
+func (v *Version) Compare(o *Version) int {
+	if c := compareUint(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.patch, o.patch); c != 0 {
+		return c
+	}
+
+	// a pre-release version has lower precedence than the release
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, b := v.pre[i], o.pre[i]
+		aNum, bNum := isNumeric(a), isNumeric(b)
+		switch {
+		case aNum && bNum:
+			an, _ := strconv.ParseUint(a, 10, 64)
+			bn, _ := strconv.ParseUint(b, 10, 64)
+			if c := compareUint(an, bn); c != 0 {
+				return c
+			}
+		case aNum:
+			return -1
+		case bNum:
+			return 1
+		case a != b:
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareUint(uint64(len(v.pre)), uint64(len(o.pre)))
+}
+
+// Compare returns -1, 0 or 1 if version a has lower, equal or higher
+// precedence than version b.
+func Compare(a, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
@@ -0,0 +1,40 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"v0.0.1", "1.2.3", "v1.0.0-rc.1", "v1.0.0-alpha-1+build.5", "v1.0.0+20170710"} {
+		if _, err := Parse(s); err != nil {
+			t.Errorf("Failed to parse %s: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "v1", "v1.2", "v1.2.3.4", "v01.2.3", "v1.2.x", "v1.2.3-", "v1.2.3-01", "v1.2.3+", "v1.2.3-a..b", "latest"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Invalid version %s parsed", s)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"v0.0.10", "v0.0.9", 1},
+		{"v1.0.0", "v1.0.0-rc1", 1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-beta", "v1.0.0-alpha.beta", 1},
+		{"v1.0.0+build.1", "1.0.0+build.2", 0},
+		{"v2.0.0", "v10.0.0", -1},
+	} {
+		got, err := Compare(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("Failed to compare %s and %s: %v", tc.a, tc.b, err)
+		}
+		if got != tc.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package signature signs and verifies signatures of release
+// binaries. All signatures are created over the SHA256 digest of the
+// binary, which is what go-update passes to its Verifier.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	update "github.com/inconshreveable/go-update"
+)
+
+// Supported signature types
+const (
+	// ECDSA signatures are ASN.1 DER encoded, p.e. created by
+	// openssl dgst -sha256 -sign
+	ECDSA = "ecdsa"
+	// Ed25519 signatures are created over the raw SHA256 digest
+	// of the binary, p.e. by openssl pkeyutl -sign -rawin
+	Ed25519 = "ed25519"
+	// RSAPSS signatures use PSS padding with SHA256, p.e. created by
+	// openssl dgst -sha256 -sigopt rsa_padding_mode:pss -sign
+	RSAPSS = "rsa-pss"
+)
+
+var (
+	ErrUnknownType      = errors.New("signature: unknown signature type")
+	ErrUnsupportedKey   = errors.New("signature: unsupported public key type")
+	ErrInvalidSignature = errors.New("signature: invalid signature")
+)
+
+// Valid returns true if signatureType is supported.
+func Valid(signatureType string) bool {
+	_, err := NewVerifier(signatureType)
+	return err == nil
+}
+
+// NewVerifier returns the go-update Verifier for signatureType.
+func NewVerifier(signatureType string) (update.Verifier, error) {
+	switch signatureType {
+	case ECDSA:
+		return update.NewECDSAVerifier(), nil
+	case Ed25519:
+		return verifyFn(verifyEd25519), nil
+	case RSAPSS:
+		return verifyFn(verifyRSAPSS), nil
+	}
+	return nil, fmt.Errorf("%v: %q", ErrUnknownType, signatureType)
+}
+
+// TypeOf returns the signature type created by the private key of pub.
+func TypeOf(pub crypto.PublicKey) (string, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return ECDSA, nil
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	case *rsa.PublicKey:
+		return RSAPSS, nil
+	}
+	return "", fmt.Errorf("%v: %T", ErrUnsupportedKey, pub)
+}
+
+// ParsePublicKeyPEM parses a PEM encoded PKIX public key.
+func ParsePublicKeyPEM(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("signature: couldn't parse PEM data")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded PKCS #8, SEC 1 EC or
+// PKCS #1 RSA private key.
+func ParsePrivateKeyPEM(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("signature: couldn't parse PEM data")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%v: %T", ErrUnsupportedKey, key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// Sign signs the SHA256 digest with priv, such that Verify accepts
+// the signature, and returns the signature and its type.
+func Sign(priv crypto.Signer, digest []byte) ([]byte, string, error) {
+	signatureType, err := TypeOf(priv.Public())
+	if err != nil {
+		return nil, "", err
+	}
+	var opts crypto.SignerOpts = crypto.SHA256
+	switch signatureType {
+	case Ed25519:
+		opts = crypto.Hash(0)
+	case RSAPSS:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	sig, err := priv.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return sig, signatureType, nil
+}
+
+// Verify verifies signature of the SHA256 digest with pub.
+func Verify(signatureType string, pub crypto.PublicKey, digest, signature []byte) error {
+	verifier, err := NewVerifier(signatureType)
+	if err != nil {
+		return err
+	}
+	return verifier.VerifySignature(digest, signature, crypto.SHA256, pub)
+}
+
+// KeyDigest returns the SHA256 digest of the DER encoded PKIX public
+// key pub. A cross-signature of a key is a signature of this digest.
+func KeyDigest(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(der)
+	return digest[:], nil
+}
+
+// VerifyKey verifies the cross-signature of pub created by the
+// private key of signer. The signature type is chosen by the type of
+// signer.
+func VerifyKey(signer, pub crypto.PublicKey, crossSignature []byte) error {
+	signatureType, err := TypeOf(signer)
+	if err != nil {
+		return err
+	}
+	digest, err := KeyDigest(pub)
+	if err != nil {
+		return err
+	}
+	return Verify(signatureType, signer, digest, crossSignature)
+}
+
+// RollbackDigest returns the SHA256 digest, which is signed to
+// authorize clients of application name running a version up to
+// from to downgrade to version to. toSHA256 is the hex encoded SHA256
+// of the binary of version to, such that the authorization can not be
+// attached to another binary.
+func RollbackDigest(name, from, to, toSHA256 string) []byte {
+	digest := sha256.Sum256([]byte("binary-patch rollback\n" + name + "\n" + from + "\n" + to + "\n" + toSHA256))
+	return digest[:]
+}
+
+type verifyFn func([]byte, []byte, crypto.Hash, crypto.PublicKey) error
+
+func (fn verifyFn) VerifySignature(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+	return fn(checksum, signature, hash, publicKey)
+}
+
+func verifyEd25519(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+	key, ok := publicKey.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("signature: not a valid Ed25519 public key")
+	}
+	if !ed25519.Verify(key, checksum, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func verifyRSAPSS(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+	key, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signature: not a valid RSA public key")
+	}
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+	if err := rsa.VerifyPSS(key, hash, checksum, signature, opts); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	digest := sha256.Sum256([]byte("binary"))
+	other := sha256.Sum256([]byte("other binary"))
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecSig, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSig := ed25519.Sign(edKey, digest[:])
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSig, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+
+	for _, tc := range []struct {
+		pub crypto.PublicKey
+		sig []byte
+	}{
+		{&ecKey.PublicKey, ecSig},
+		{edPub, edSig},
+		{&rsaKey.PublicKey, rsaSig},
+	} {
+		der, err := x509.MarshalPKIXPublicKey(tc.pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		if err != nil {
+			t.Fatalf("Failed to parse %T: %v", tc.pub, err)
+		}
+		typ, err := TypeOf(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(typ, pub, digest[:], tc.sig); err != nil {
+			t.Errorf("Failed to verify %s signature: %v", typ, err)
+		}
+		if err := Verify(typ, pub, other[:], tc.sig); err == nil {
+			t.Errorf("Verified %s signature of other data", typ)
+		}
+	}
+
+	if err := Verify("dsa", &ecKey.PublicKey, digest[:], ecSig); err == nil {
+		t.Error("Verified unknown signature type")
+	}
+	if err := Verify(Ed25519, &ecKey.PublicKey, digest[:], ecSig); err == nil {
+		t.Error("Verified signature with wrong key type")
+	}
+}
+
+func TestSign(t *testing.T) {
+	digest := sha256.Sum256([]byte("manifest"))
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for _, priv := range []crypto.Signer{ecKey, edKey, rsaKey} {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		if err != nil {
+			t.Fatalf("Failed to parse %T: %v", priv, err)
+		}
+		sig, typ, err := Sign(signer, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign with %T: %v", priv, err)
+		}
+		if err := Verify(typ, priv.Public(), digest[:], sig); err != nil {
+			t.Errorf("Failed to verify %s signature: %v", typ, err)
+		}
+	}
+}